@@ -0,0 +1,113 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/collections/sketch"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultAdmissionMinMisses = 2
+	defaultAdmissionWindow    = time.Minute
+	defaultAdmissionWidth     = 1 << 16
+	admissionDepth            = 4
+)
+
+var admission *admissionFilter
+
+// AdmissionConfig enables an admission filter in front of the cache writes made on misses. An entry is only written
+// once its key has been missed MinMisses times within Window, so that one-off requests do not take up cache memory
+// and write bandwidth. Zero values fall back to the defaults.
+type AdmissionConfig struct {
+	// MinMisses is the number of misses of a key within Window after which its entry is written. Defaults to 2.
+	MinMisses int `json:"min_misses,omitempty" yaml:"min_misses,omitempty" xml:"min_misses,omitempty"`
+	// Window is the period after which miss counts are halved. Defaults to 1 minute.
+	Window time.Duration `json:"window,omitempty" yaml:"window,omitempty" xml:"window,omitempty"`
+	// Width is the number of counters per row of the Count-Min sketch counting misses. A larger width counts more
+	// distinct keys accurately, and costs 16 bytes per unit. Defaults to 65536.
+	Width int `json:"width,omitempty" yaml:"width,omitempty" xml:"width,omitempty"`
+}
+
+func (c *AdmissionConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MinMisses < 0 || c.Window < 0 || c.Width < 0 {
+		return errors.Errorf("admission config cannot have negative values")
+	}
+	return nil
+}
+
+type admissionFilter struct {
+	minMisses uint32
+	window    time.Duration
+
+	mu        sync.Mutex
+	misses    *sketch.CountMin
+	lastDecay time.Time
+}
+
+func newAdmissionFilter(cfg *AdmissionConfig) *admissionFilter {
+	return &admissionFilter{
+		minMisses: uint32(helpers.TernaryOp(cfg.MinMisses > 0, cfg.MinMisses, defaultAdmissionMinMisses)),
+		window:    helpers.TernaryOp(cfg.Window > 0, cfg.Window, defaultAdmissionWindow),
+		misses:    sketch.NewCountMin(helpers.TernaryOp(cfg.Width > 0, cfg.Width, defaultAdmissionWidth), admissionDepth),
+		lastDecay: time.Now(),
+	}
+}
+
+// admit records a miss of key and reports whether its entry should be written.
+func (f *admissionFilter) admit(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now := time.Now(); now.Sub(f.lastDecay) >= f.window {
+		f.misses.Decay()
+		f.lastDecay = now
+	}
+	return f.misses.Add(key) >= f.minMisses
+}
+
+// InjectAdmissionConfig sets the admission filter of the cache writes made on misses. A nil config admits every write.
+func InjectAdmissionConfig(cfg *AdmissionConfig) {
+	admission = nil
+	if cfg != nil {
+		admission = newAdmissionFilter(cfg)
+	}
+}
+
+// admitWrite reports whether the entry of key, which was just missed, should be written to the cache. Warm-up calls
+// are always admitted, as the whole point of warming up is to write their entries.
+func admitWrite(ctx context.Context, key, rpcCallName string) bool {
+	f := admission
+	if f == nil || isWarmUp(ctx) || f.admit(key) {
+		return true
+	}
+
+	if !isSkipMetrics() {
+		metricsProvider.IncreaseAdmissionSkipMetric(ctx, rpcCallName)
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/helpers"
+)
+
+func TestAdmissionFilter(t *testing.T) {
+	f := newAdmissionFilter(&AdmissionConfig{MinMisses: 3, Window: 50 * time.Millisecond, Width: 1024})
+	assert.False(t, f.admit("key"))
+	assert.False(t, f.admit("key"))
+	assert.True(t, f.admit("key"))
+	assert.False(t, f.admit("other"))
+	assert.False(t, f.admit("other"))
+
+	// counts are halved every window
+	f.lastDecay = time.Now().Add(-time.Second)
+	assert.False(t, f.admit("other"))
+	assert.True(t, f.admit("other"))
+}
+
+func TestAdmissionOnCacheMiss(t *testing.T) {
+	waitForBackgroundTasks()
+	data := map[string]any{}
+	mockCache(data)
+	InjectAdmissionConfig(&AdmissionConfig{MinMisses: 2})
+	t.Cleanup(func() { InjectAdmissionConfig(nil) })
+
+	c := &TestRPCClient{}
+	cacheKey, _ := helpers.GenerateCacheKey(testReq, helpers.GetFunctionName(c.TestRPCCall), defaultSoftTTL, defaultHardTTL, version)
+
+	for _, admitted := range []bool{false, true} {
+		got, err := GRPCCall(c.TestRPCCall, context.Background(), testReq)
+		assert.NoError(t, err)
+		assert.Equal(t, testResp, got)
+
+		waitForBackgroundTasks()
+		_, err = cacheProvider.Get(context.Background(), cacheKey)
+		assert.Equal(t, admitted, err == nil)
+	}
+}
+
+func TestAdmissionSkippedByWarm(t *testing.T) {
+	waitForBackgroundTasks()
+	data := map[string]any{}
+	mockCache(data)
+	InjectAdmissionConfig(&AdmissionConfig{MinMisses: 2})
+	t.Cleanup(func() { InjectAdmissionConfig(nil) })
+
+	c := &TestRPCClient{}
+	cacheKey, _ := helpers.GenerateCacheKey(testReq, helpers.GetFunctionName(c.TestRPCCall), defaultSoftTTL, defaultHardTTL, version)
+
+	report, err := Warm(context.Background(), func(ctx context.Context, yield func(call WarmCall) bool) error {
+		yield(NewWarmCall(c.TestRPCCall, testReq))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Succeeded)
+
+	waitForBackgroundTasks()
+	_, err = cacheProvider.Get(context.Background(), cacheKey)
+	assert.NoError(t, err)
+}
@@ -18,6 +18,8 @@ package cache
 
 import (
 	"context"
+	"io"
+	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
@@ -32,10 +34,22 @@ type Client struct {
 	GetAPI IGet
 	// SetAPI is any cache client that can set items
 	SetAPI ISet
+
+	timeout time.Duration
+	breaker *circuitBreaker
+	hotKeys *hotKeyDetector
+	// chunkSize is the size above which values are chunked. 0 disables chunking.
+	chunkSize int
+	// maxChunks is the maximum number of chunks of a value. 0 means the default.
+	maxChunks int
 }
 
 var CompressionLibrary constants.CompressionLibraryType
 
+// ErrNotFound should be returned by cache clients when a key does not exist, so that a cache miss is not
+// mistaken for a cache failure by the circuit breaker.
+var ErrNotFound = errors.New("key not found in cache")
+
 // IGet is an interface for all cache clients that support Get operations.
 type IGet interface {
 	Get(ctx context.Context, key string) ([]byte, error)
@@ -46,21 +60,177 @@ type ISet interface {
 	Set(ctx context.Context, key string, val any, ttl time.Duration) error
 }
 
+// IDelete is an optional interface for cache clients that support Delete operations.
+type IDelete interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrDeleteNotSupported is returned by Delete if neither the get nor the set client supports Delete operations.
+var ErrDeleteNotSupported = errors.New("cache client does not support delete")
+
 // Get simply gets an item from the cache based on the API provided by the cache client.
 func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
-	compressedData, err := c.GetAPI.Get(ctx, key)
+	if c.hotKeys != nil {
+		if val, ok := c.hotKeys.get(key); ok {
+			return val, nil
+		}
+	}
+
+	var compressedData []byte
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		compressedData, err = c.GetAPI.Get(ctx, key)
+		return err
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to pull from cache")
 	}
 
+	if isManifest(compressedData) {
+		if compressedData, err = c.getChunked(ctx, key, compressedData); err != nil {
+			return nil, errors.Wrap(err, "unable to pull chunked value from cache")
+		}
+	}
+
+	if c.hotKeys != nil {
+		c.hotKeys.observe(key, operationName(ctx), compressedData)
+	}
 	return compressedData, nil
 }
 
-// Set simply sets an item in the cache based on the API provided by the cache client.
+// Set simply sets an item in the cache based on the API provided by the cache client. If chunking is enabled,
+// values larger than the chunk size are stored in chunks.
 func (c *Client) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
-	err := c.SetAPI.Set(ctx, key, val, ttl)
+	if b, ok := val.([]byte); ok && c.chunkSize > 0 && len(b) > c.chunkSize {
+		return c.SetChunked(ctx, key, b, c.chunkSize, ttl)
+	}
+	return c.set(ctx, key, val, ttl)
+}
+
+func (c *Client) set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	if c.hotKeys != nil {
+		c.hotKeys.unpin(key)
+	}
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.SetAPI.Set(ctx, key, val, ttl)
+	})
 	if err != nil {
 		return errors.Wrap(err, "unable to set into cache")
 	}
 	return nil
 }
+
+// Delete deletes an item from the cache if the cache client implements IDelete. If the item is a chunked value, its
+// chunks are deleted as well.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	deleter, ok := c.deleter()
+	if !ok {
+		return ErrDeleteNotSupported
+	}
+
+	manifest := c.readManifest(ctx, key)
+	if c.hotKeys != nil {
+		c.hotKeys.unpin(key)
+	}
+	err := c.do(ctx, func(ctx context.Context) error {
+		return deleter.Delete(ctx, key)
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to delete from cache")
+	}
+	if manifest != nil {
+		c.deleteChunks(ctx, key, manifest)
+	}
+	return nil
+}
+
+func (c *Client) deleter() (IDelete, bool) {
+	if deleter, ok := c.SetAPI.(IDelete); ok {
+		return deleter, true
+	}
+	deleter, ok := c.GetAPI.(IDelete)
+	return deleter, ok
+}
+
+// CircuitState returns the current state of the circuit breaker. It is always CircuitClosed if no breaker is configured.
+func (c *Client) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	return c.breaker.currentState()
+}
+
+// OnCircuitStateChange registers a listener that is called whenever the circuit breaker changes state.
+// It must be called before the client is used.
+func (c *Client) OnCircuitStateChange(listener func(from, to CircuitState)) {
+	if c.breaker == nil {
+		return
+	}
+	c.breaker.onStateChange(listener)
+}
+
+// HotKeys returns up to n of the most read keys seen by the hot key detector, most read first.
+// It returns nil if hot key detection is not enabled.
+func (c *Client) HotKeys(n int) []HotKeyStat {
+	if c.hotKeys == nil {
+		return nil
+	}
+	return c.hotKeys.stats(n)
+}
+
+// OnHotKeyEvent registers a listener that is called when a hot key is detected and when a read is served from the
+// local cache, with the key and the operation name set on the read with WithOperationName. It must be called before
+// the client is used.
+func (c *Client) OnHotKeyEvent(listener func(event HotKeyEvent, key, name string)) {
+	if c.hotKeys == nil {
+		return
+	}
+	c.hotKeys.listeners = append(c.hotKeys.listeners, listener)
+}
+
+// do runs a cache operation bounded by the operation timeout and guarded by the circuit breaker.
+func (c *Client) do(ctx context.Context, op func(ctx context.Context) error) error {
+	var generation uint64
+	if c.breaker != nil {
+		var allowed bool
+		if generation, allowed = c.breaker.allow(); !allowed {
+			return ErrCircuitOpen
+		}
+	}
+
+	opCtx := ctx
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		opCtx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	err := op(opCtx)
+	if c.breaker != nil {
+		c.breaker.record(generation, classifyOutcome(ctx, err))
+	}
+	return err
+}
+
+// Close closes the underlying cache clients if they implement io.Closer.
+func (c *Client) Close() error {
+	var err error
+	if closer, ok := c.GetAPI.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if closer, ok := c.SetAPI.(io.Closer); ok && !isSameClient(c.GetAPI, c.SetAPI) {
+		if setErr := closer.Close(); err == nil {
+			err = setErr
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to close cache")
+	}
+	return nil
+}
+
+func isSameClient(a, b any) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestDelete(t *testing.T) {
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: &countingCache{}},
+	}).Freeze()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, client.Delete(context.Background(), "key"), ErrDeleteNotSupported)
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+)
+
+const (
+	// chunkTTLGrace keeps chunks alive a little longer than their manifest, as they are written before it.
+	chunkTTLGrace = time.Minute
+	// defaultMaxChunks bounds the number of chunks of a value, as manifests are read from the cache and cannot be trusted.
+	defaultMaxChunks = 1024
+	// chunkFetchConcurrency bounds the number of chunks of a value fetched at the same time.
+	chunkFetchConcurrency = 8
+)
+
+// manifestMagic prefixes the manifest stored under the key of a chunked value, in place of the value itself.
+var manifestMagic = []byte("heimdall:chunked:")
+
+// ErrChunkIntegrity is returned when a chunked value does not match the size or checksum recorded in its manifest.
+var ErrChunkIntegrity = errors.New("chunked value failed integrity verification")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChunkingConfig enables the chunking layer, which transparently stores values larger than ChunkSize as fixed-size
+// chunks plus a manifest, for caches that cap the size of their items.
+type ChunkingConfig struct {
+	// ChunkSize is the maximum size in bytes of a value or chunk stored in the cache. It is required.
+	ChunkSize int
+	// MaxChunks is the maximum number of chunks of a value. Larger values are not written, and manifests describing
+	// more chunks are rejected on read. Defaults to 1024.
+	MaxChunks int
+}
+
+// Validate validates the chunking configuration.
+func (c *ChunkingConfig) Validate() error {
+	if c.ChunkSize <= 0 {
+		return errors.Errorf("chunk size must be positive")
+	}
+	if c.MaxChunks < 0 {
+		return errors.Errorf("max chunks cannot be negative")
+	}
+	return nil
+}
+
+// chunkManifest describes a chunked value. Every write uses a new generation, so the chunks of concurrent or
+// successive writes never mix: a manifest only ever points at the chunks written with it.
+type chunkManifest struct {
+	Generation string `json:"generation"`
+	Chunks     int    `json:"chunks"`
+	Size       int    `json:"size"`
+	Checksum   uint32 `json:"checksum"`
+}
+
+// validate checks a manifest read from the cache before it is used, as the cache cannot be trusted.
+func (m *chunkManifest) validate(maxChunks int) error {
+	if m.Chunks <= 0 || m.Size < 0 || m.Chunks > m.Size || m.Chunks > maxChunks {
+		return errors.Wrapf(ErrChunkIntegrity, "invalid manifest of %d chunks and %d bytes", m.Chunks, m.Size)
+	}
+	return nil
+}
+
+// SetChunked splits val into chunks of at most chunkSize bytes stored under keys derived from key and a new generation
+// id, then stores a manifest under key. Get reassembles and verifies the value transparently. Once the new manifest is
+// written, the chunks of the previous one are deleted if the cache client supports Delete operations.
+func (c *Client) SetChunked(ctx context.Context, key string, val []byte, chunkSize int, ttl time.Duration) error {
+	if chunkSize <= 0 {
+		return errors.Errorf("chunk size must be positive")
+	}
+	if len(val) == 0 {
+		return errors.Errorf("cannot chunk an empty value")
+	}
+	if chunks := (len(val) + chunkSize - 1) / chunkSize; chunks > c.maxChunkCount() {
+		return errors.Errorf("value of %d bytes needs %d chunks, more than the maximum of %d", len(val), chunks, c.maxChunkCount())
+	}
+
+	generation, err := newGeneration()
+	if err != nil {
+		return err
+	}
+	manifest := chunkManifest{
+		Generation: generation,
+		Chunks:     (len(val) + chunkSize - 1) / chunkSize,
+		Size:       len(val),
+		Checksum:   crc32.Checksum(val, crc32cTable),
+	}
+
+	chunkTTL := ttl
+	if ttl > 0 {
+		chunkTTL += chunkTTLGrace
+	}
+	for i := 0; i < manifest.Chunks; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(val) {
+			end = len(val)
+		}
+		if err = c.set(ctx, chunkKey(key, generation, i), val[i*chunkSize:end], chunkTTL); err != nil {
+			return errors.Wrapf(err, "unable to set chunk %d", i)
+		}
+	}
+
+	// the manifest is written last, so that it never points at chunks that do not exist yet.
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "unable to marshal chunk manifest")
+	}
+	previous := c.readManifest(ctx, key)
+	if err = c.set(ctx, key, append(append([]byte{}, manifestMagic...), encoded...), ttl); err != nil {
+		return err
+	}
+	if previous != nil && previous.Generation != generation {
+		c.deleteChunks(ctx, key, previous)
+	}
+	return nil
+}
+
+// readManifest returns the manifest stored under key, or nil if there is none or it cannot be read.
+func (c *Client) readManifest(ctx context.Context, key string) *chunkManifest {
+	var val []byte
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		val, err = c.GetAPI.Get(ctx, key)
+		return err
+	})
+	if err != nil || !isManifest(val) {
+		return nil
+	}
+	manifest, err := c.unmarshalManifest(val)
+	if err != nil {
+		return nil
+	}
+	return manifest
+}
+
+// deleteChunks deletes the chunks of a manifest that was replaced or deleted. It is best effort: chunks that cannot be
+// deleted expire shortly after their manifest would have.
+func (c *Client) deleteChunks(ctx context.Context, key string, manifest *chunkManifest) {
+	deleter, ok := c.deleter()
+	if !ok {
+		return
+	}
+	for i := 0; i < manifest.Chunks; i++ {
+		chunk := chunkKey(key, manifest.Generation, i)
+		_ = c.do(ctx, func(ctx context.Context) error {
+			return deleter.Delete(ctx, chunk)
+		})
+	}
+}
+
+func (c *Client) unmarshalManifest(val []byte) (*chunkManifest, error) {
+	manifest := &chunkManifest{}
+	if err := json.Unmarshal(val[len(manifestMagic):], manifest); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal chunk manifest")
+	}
+	if err := manifest.validate(c.maxChunkCount()); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (c *Client) maxChunkCount() int {
+	if c.maxChunks > 0 {
+		return c.maxChunks
+	}
+	return defaultMaxChunks
+}
+
+func isManifest(val []byte) bool {
+	return bytes.HasPrefix(val, manifestMagic)
+}
+
+// getChunked fetches the chunks described by the manifest stored under key with bounded concurrency, then reassembles
+// and verifies the value.
+func (c *Client) getChunked(ctx context.Context, key string, val []byte) ([]byte, error) {
+	manifest, err := c.unmarshalManifest(val)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		next   int64 = -1
+		chunks       = make([][]byte, manifest.Chunks)
+		errs         = make([]error, manifest.Chunks)
+	)
+	workers := chunkFetchConcurrency
+	if manifest.Chunks < workers {
+		workers = manifest.Chunks
+	}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(atomic.AddInt64(&next, 1)); i < manifest.Chunks; i = int(atomic.AddInt64(&next, 1)) {
+				errs[i] = c.do(ctx, func(ctx context.Context) (err error) {
+					chunks[i], err = c.GetAPI.Get(ctx, chunkKey(key, manifest.Generation, i))
+					return err
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	assembled := make([]byte, 0, manifest.Size)
+	for i, chunk := range chunks {
+		if errs[i] != nil {
+			return nil, errors.Wrapf(errs[i], "unable to get chunk %d", i)
+		}
+		assembled = append(assembled, chunk...)
+	}
+
+	if len(assembled) != manifest.Size {
+		return nil, errors.Wrapf(ErrChunkIntegrity, "%d bytes, expected %d", len(assembled), manifest.Size)
+	}
+	if crc32.Checksum(assembled, crc32cTable) != manifest.Checksum {
+		return nil, errors.Wrap(ErrChunkIntegrity, "checksum mismatch")
+	}
+	return assembled, nil
+}
+
+func chunkKey(key, generation string, i int) string {
+	return fmt.Sprintf("%s:chunk:%s:%d", key, generation, i)
+}
+
+func newGeneration() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "unable to generate chunk generation")
+	}
+	return hex.EncodeToString(b), nil
+}
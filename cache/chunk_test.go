@@ -0,0 +1,167 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestSetChunked(t *testing.T) {
+	counting := &countingCache{}
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: counting},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	val := bytes.Repeat([]byte("0123456789"), 10)
+	assert.NoError(t, client.SetChunked(ctx, "key", val, 30, time.Minute))
+	assert.Len(t, counting.data, 5)
+
+	got, err := client.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, val, got)
+
+	assert.Error(t, client.SetChunked(ctx, "key", val, 0, time.Minute))
+}
+
+func TestChunkingLayer(t *testing.T) {
+	counting := &deletingCache{}
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: counting},
+		ChunkingConfig:      &ChunkingConfig{ChunkSize: 30},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+
+	// small values are not chunked
+	assert.NoError(t, client.Set(ctx, "small", []byte("small"), time.Minute))
+	assert.Equal(t, []byte("small"), counting.data["small"])
+
+	first := bytes.Repeat([]byte("a"), 100)
+	assert.NoError(t, client.Set(ctx, "key", first, time.Minute))
+	assert.True(t, isManifest(counting.data["key"]))
+
+	// a new write uses a new generation, so it never mixes with the chunks of the previous one, which are deleted
+	second := bytes.Repeat([]byte("b"), 70)
+	assert.NoError(t, client.Set(ctx, "key", second, time.Minute))
+	assert.Len(t, counting.data, 1+1+3)
+	got, err := client.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, second, got)
+
+	// a corrupted chunk fails the read rather than returning a wrong value
+	for k, v := range counting.data {
+		if strings.Contains(k, ":chunk:") && v[0] == 'b' {
+			counting.data[k] = bytes.Repeat([]byte("c"), len(v))
+			break
+		}
+	}
+	_, err = client.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrChunkIntegrity)
+}
+
+func TestChunkManifestValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{
+			name:     "no chunks",
+			manifest: `{"generation":"g","chunks":0,"size":0}`,
+		}, {
+			name:     "negative size",
+			manifest: `{"generation":"g","chunks":1,"size":-1}`,
+		}, {
+			name:     "more chunks than bytes",
+			manifest: `{"generation":"g","chunks":10,"size":5}`,
+		}, {
+			name:     "too many chunks",
+			manifest: `{"generation":"g","chunks":4,"size":100}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counting := &countingCache{data: map[string][]byte{"key": append(append([]byte{}, manifestMagic...), tt.manifest...)}}
+			client, err := (&Config{
+				CacheProvider:       constants.CustomCacheType,
+				CustomConfiguration: &CustomConfig{Client: counting},
+				ChunkingConfig:      &ChunkingConfig{ChunkSize: 30, MaxChunks: 3},
+			}).Freeze()
+			assert.NoError(t, err)
+
+			_, err = client.Get(context.Background(), "key")
+			assert.ErrorIs(t, err, ErrChunkIntegrity)
+			// no chunk is fetched for an invalid manifest
+			assert.Len(t, counting.gets, 1)
+		})
+	}
+}
+
+func TestChunkedWriteLimits(t *testing.T) {
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: &countingCache{}},
+		ChunkingConfig:      &ChunkingConfig{ChunkSize: 10, MaxChunks: 3},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, client.Set(ctx, "key", bytes.Repeat([]byte("a"), 30), time.Minute))
+	assert.Error(t, client.Set(ctx, "key", bytes.Repeat([]byte("a"), 31), time.Minute))
+	assert.Error(t, client.SetChunked(ctx, "key", nil, 10, time.Minute))
+}
+
+func TestDeleteChunked(t *testing.T) {
+	counting := &deletingCache{}
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: counting},
+		ChunkingConfig:      &ChunkingConfig{ChunkSize: 10},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, client.Set(ctx, "key", bytes.Repeat([]byte("a"), 100), time.Minute))
+	assert.Len(t, counting.data, 1+10)
+	assert.NoError(t, client.Delete(ctx, "key"))
+	assert.Empty(t, counting.data)
+}
+
+// deletingCache is a countingCache that supports Delete operations.
+type deletingCache struct {
+	countingCache
+}
+
+func (c *deletingCache) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.data, key)
+	return nil
+}
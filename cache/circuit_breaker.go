@@ -0,0 +1,230 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultFailureThreshold = 5
+	defaultOpenDuration     = 5 * time.Second
+	defaultHalfOpenProbes   = 1
+)
+
+// ErrCircuitOpen is returned by the cache client when the circuit breaker is open and the cache is bypassed.
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+// CircuitState is the state of the cache circuit breaker.
+type CircuitState int32
+
+const (
+	// CircuitClosed lets all cache operations through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fails all cache operations immediately with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe operations through to find out whether the cache has recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig is the configuration for the circuit breaker around the cache. Zero values fall back to the defaults.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed or timed out operations that trips the breaker. Defaults to 5.
+	FailureThreshold int
+	// OpenDuration is how long the breaker bypasses the cache before probing it again. Defaults to 5 seconds.
+	OpenDuration time.Duration
+	// HalfOpenProbes is the number of successful probes required to close the breaker again. Defaults to 1.
+	HalfOpenProbes int
+}
+
+// Validate validates the circuit breaker configuration.
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold < 0 || c.OpenDuration < 0 || c.HalfOpenProbes < 0 {
+		return errors.Errorf("circuit breaker config cannot have negative values")
+	}
+	return nil
+}
+
+type circuitBreaker struct {
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+
+	mu    sync.Mutex
+	state CircuitState
+	// generation is bumped on every state change, so that operations admitted under a previous state are not counted
+	// under the current one.
+	generation     uint64
+	failures       int
+	successes      int
+	probesInFlight int
+	openedAt       time.Time
+	listeners      []func(from, to CircuitState)
+}
+
+// operationOutcome is the outcome of a cache operation, as far as the circuit breaker is concerned.
+type operationOutcome int
+
+const (
+	operationSucceeded operationOutcome = iota
+	operationFailed
+	// operationAbandoned is an operation abandoned by the caller, which says nothing about the health of the cache.
+	operationAbandoned
+)
+
+// stateChange is a state transition whose listeners are yet to be called.
+type stateChange struct {
+	from, to  CircuitState
+	listeners []func(from, to CircuitState)
+}
+
+func newCircuitBreaker(cfg *CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: helpers.TernaryOp(cfg.FailureThreshold > 0, cfg.FailureThreshold, defaultFailureThreshold),
+		openDuration:     helpers.TernaryOp(cfg.OpenDuration > 0, cfg.OpenDuration, defaultOpenDuration),
+		halfOpenProbes:   helpers.TernaryOp(cfg.HalfOpenProbes > 0, cfg.HalfOpenProbes, defaultHalfOpenProbes),
+	}
+}
+
+// allow reports whether an operation may be sent to the cache. It also returns the generation the operation was
+// admitted under, which must be passed back to record.
+func (b *circuitBreaker) allow() (uint64, bool) {
+	var change *stateChange
+	defer func() { change.notify() }()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		if time.Since(b.openedAt) < b.openDuration {
+			return b.generation, false
+		}
+		change = b.transition(CircuitHalfOpen)
+	}
+
+	if b.state == CircuitHalfOpen {
+		if b.probesInFlight >= b.halfOpenProbes {
+			return b.generation, false
+		}
+		b.probesInFlight++
+	}
+	return b.generation, true
+}
+
+// record records the outcome of an operation that was allowed through under the given generation.
+// Outcomes of operations admitted under a previous generation are ignored.
+func (b *circuitBreaker) record(generation uint64, outcome operationOutcome) {
+	var change *stateChange
+	defer func() { change.notify() }()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case CircuitClosed:
+		switch outcome {
+		case operationSucceeded:
+			b.failures = 0
+		case operationFailed:
+			b.failures++
+			if b.failures >= b.failureThreshold {
+				change = b.transition(CircuitOpen)
+			}
+		}
+	case CircuitHalfOpen:
+		if b.probesInFlight > 0 {
+			b.probesInFlight--
+		}
+		switch outcome {
+		case operationSucceeded:
+			b.successes++
+			if b.successes >= b.halfOpenProbes {
+				change = b.transition(CircuitClosed)
+			}
+		case operationFailed:
+			change = b.transition(CircuitOpen)
+		}
+	}
+}
+
+// transition must be called with the lock held. The returned state change must be notified once the lock is released,
+// so that listeners neither stall cache operations nor deadlock when they read the state.
+func (b *circuitBreaker) transition(to CircuitState) *stateChange {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.failures, b.successes, b.probesInFlight = 0, 0, 0
+	if to == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+	return &stateChange{from: from, to: to, listeners: append(([]func(from, to CircuitState))(nil), b.listeners...)}
+}
+
+func (c *stateChange) notify() {
+	if c == nil {
+		return
+	}
+	for _, listener := range c.listeners {
+		listener(c.from, c.to)
+	}
+}
+
+func (b *circuitBreaker) onStateChange(listener func(from, to CircuitState)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+func (b *circuitBreaker) currentState() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// classifyOutcome decides how an error returned by the cache counts towards tripping the breaker.
+// Cache misses are not failures of the cache, and operations abandoned by the caller are not counted at all.
+func classifyOutcome(ctx context.Context, err error) operationOutcome {
+	switch {
+	case ctx.Err() != nil:
+		return operationAbandoned
+	case err == nil || errors.Is(err, ErrNotFound):
+		return operationSucceeded
+	default:
+		return operationFailed
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	slow := &slowCache{delay: 50 * time.Millisecond}
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: slow},
+		OperationTimeout:    5 * time.Millisecond,
+		CircuitBreakerConfig: &CircuitBreakerConfig{
+			FailureThreshold: 2,
+			OpenDuration:     20 * time.Millisecond,
+		},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	var transitions []string
+	client.OnCircuitStateChange(func(from, to CircuitState) {
+		transitions = append(transitions, from.String()+"->"+to.String())
+	})
+
+	ctx := context.Background()
+
+	// timeouts trip the breaker
+	for i := 0; i < 2; i++ {
+		_, err = client.Get(ctx, "key")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+
+	// the cache is bypassed while the breaker is open
+	start := time.Now()
+	_, err = client.Get(ctx, "key")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Less(t, time.Since(start), 5*time.Millisecond)
+
+	// a failed probe opens the breaker again
+	time.Sleep(20 * time.Millisecond)
+	_, err = client.Get(ctx, "key")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+
+	// a successful probe closes the breaker
+	slow.delay = 0
+	time.Sleep(20 * time.Millisecond)
+	_, err = client.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, client.CircuitState())
+
+	// misses do not trip the breaker
+	slow.err = ErrNotFound
+	for i := 0; i < 3; i++ {
+		_, err = client.Get(ctx, "key")
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.Equal(t, CircuitClosed, client.CircuitState())
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half_open",
+		"half_open->open",
+		"open->half_open",
+		"half_open->closed",
+	}, transitions)
+}
+
+type slowCache struct {
+	delay time.Duration
+	err   error
+}
+
+func (c *slowCache) Get(ctx context.Context, key string) ([]byte, error) {
+	select {
+	case <-time.After(c.delay):
+		return nil, c.err
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
+func (c *slowCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	_, err := c.Get(ctx, key)
+	return err
+}
+
+func TestCircuitBreakerOutcomes(t *testing.T) {
+	cfg := &CircuitBreakerConfig{FailureThreshold: 2, OpenDuration: time.Millisecond}
+
+	t.Run("abandoned probe does not close the breaker", func(t *testing.T) {
+		b := newCircuitBreaker(cfg)
+		b.transition(CircuitOpen)
+		time.Sleep(2 * time.Millisecond)
+
+		generation, allowed := b.allow()
+		assert.True(t, allowed)
+		b.record(generation, operationAbandoned)
+		assert.Equal(t, CircuitHalfOpen, b.currentState())
+
+		// the probe slot is released
+		generation, allowed = b.allow()
+		assert.True(t, allowed)
+		b.record(generation, operationSucceeded)
+		assert.Equal(t, CircuitClosed, b.currentState())
+	})
+
+	t.Run("abandoned operation does not reset failures", func(t *testing.T) {
+		b := newCircuitBreaker(cfg)
+		for _, outcome := range []operationOutcome{operationFailed, operationAbandoned, operationFailed} {
+			generation, _ := b.allow()
+			b.record(generation, outcome)
+		}
+		assert.Equal(t, CircuitOpen, b.currentState())
+	})
+
+	t.Run("outcome from a previous generation is ignored", func(t *testing.T) {
+		b := newCircuitBreaker(cfg)
+		stale, _ := b.allow()
+		b.transition(CircuitOpen)
+		time.Sleep(2 * time.Millisecond)
+
+		_, allowed := b.allow()
+		assert.True(t, allowed)
+		b.record(stale, operationSucceeded)
+		assert.Equal(t, CircuitHalfOpen, b.currentState())
+		assert.Equal(t, 1, b.probesInFlight)
+	})
+}
+
+func TestCircuitBreakerListenerReadsState(t *testing.T) {
+	client, err := (&Config{
+		CacheProvider:        constants.CustomCacheType,
+		CustomConfiguration:  &CustomConfig{Client: &slowCache{err: errors.New("unavailable")}},
+		CircuitBreakerConfig: &CircuitBreakerConfig{FailureThreshold: 1},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	var states []CircuitState
+	client.OnCircuitStateChange(func(from, to CircuitState) {
+		states = append(states, client.CircuitState())
+	})
+
+	_, err = client.Get(context.Background(), "key")
+	assert.Error(t, err)
+	assert.Equal(t, []CircuitState{CircuitOpen}, states)
+}
+
+func TestClassifyOutcome(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, operationSucceeded, classifyOutcome(context.Background(), nil))
+	assert.Equal(t, operationSucceeded, classifyOutcome(context.Background(), ErrNotFound))
+	assert.Equal(t, operationFailed, classifyOutcome(context.Background(), errors.New("unavailable")))
+	assert.Equal(t, operationAbandoned, classifyOutcome(cancelled, context.Canceled))
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/collections/sketch"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultHotKeyCapacity     = 1000
+	defaultHotKeyThreshold    = 100
+	defaultHotKeyWindow       = time.Second
+	defaultHotKeyLocalTTL     = time.Second
+	defaultHotKeyMaxLocalKeys = 100
+)
+
+// HotKeyEvent is an event of the hot key detector.
+type HotKeyEvent int
+
+const (
+	// HotKeyDetected is raised when a key becomes hot and is pinned in the local cache. It is raised again only once
+	// the key has cooled down and become hot again, not every time a hot key is pinned again after LocalTTL.
+	HotKeyDetected HotKeyEvent = iota
+	// HotKeyLocalHit is raised when a read is served from the local cache.
+	HotKeyLocalHit
+)
+
+// HotKeyConfig enables the detection of hot keys among the reads sent to the cache. Hot keys are pinned in a small
+// in-process cache for a short TTL, so that a single very popular key does not saturate one cache shard.
+// Zero values fall back to the defaults.
+type HotKeyConfig struct {
+	// Capacity is the number of keys monitored by the heavy hitters sketch. Defaults to 1000.
+	Capacity int
+	// Threshold is the number of reads of a key within Window above which the key is hot. Defaults to 100.
+	Threshold int
+	// Window is the period after which read counts are halved, so that keys that cool down stop being hot.
+	// Defaults to 1 second.
+	Window time.Duration
+	// LocalTTL is how long a hot key is served from the local cache before it is read from the cache again.
+	// This bounds how stale a hot key can be. Defaults to 1 second.
+	LocalTTL time.Duration
+	// MaxLocalKeys is the maximum number of keys pinned in the local cache. Defaults to 100.
+	MaxLocalKeys int
+}
+
+// Validate validates the hot key configuration.
+func (c *HotKeyConfig) Validate() error {
+	if c.Capacity < 0 || c.Threshold < 0 || c.Window < 0 || c.LocalTTL < 0 || c.MaxLocalKeys < 0 {
+		return errors.Errorf("hot key config cannot have negative values")
+	}
+	return nil
+}
+
+// HotKeyStat describes a key monitored by the hot key detector.
+type HotKeyStat struct {
+	// Key is the cache key.
+	Key string
+	// Reads is the estimated number of recent reads of the key, decayed every window.
+	Reads uint64
+	// Pinned reports whether the key is currently served from the local cache.
+	Pinned bool
+}
+
+type operationNameKey struct{}
+
+// WithOperationName annotates ctx with the name of the operation the cache is read for, such as an rpc call name.
+// The name is passed to the hot key listeners.
+func WithOperationName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationNameKey{}, name)
+}
+
+func operationName(ctx context.Context) string {
+	name, _ := ctx.Value(operationNameKey{}).(string)
+	return name
+}
+
+type pinnedKey struct {
+	val       []byte
+	name      string
+	expiresAt time.Time
+}
+
+type hotKeyDetector struct {
+	threshold    uint64
+	window       time.Duration
+	localTTL     time.Duration
+	maxLocalKeys int
+
+	mu        sync.Mutex
+	reads     *sketch.SpaceSaving[string]
+	lastDecay time.Time
+	pinned    map[string]*pinnedKey
+	// hot holds the keys that are hot, so that HotKeyDetected is only raised when a key becomes hot.
+	hot       map[string]struct{}
+	listeners []func(event HotKeyEvent, key, name string)
+}
+
+func newHotKeyDetector(cfg *HotKeyConfig) *hotKeyDetector {
+	return &hotKeyDetector{
+		threshold:    uint64(helpers.TernaryOp(cfg.Threshold > 0, cfg.Threshold, defaultHotKeyThreshold)),
+		window:       helpers.TernaryOp(cfg.Window > 0, cfg.Window, defaultHotKeyWindow),
+		localTTL:     helpers.TernaryOp(cfg.LocalTTL > 0, cfg.LocalTTL, defaultHotKeyLocalTTL),
+		maxLocalKeys: helpers.TernaryOp(cfg.MaxLocalKeys > 0, cfg.MaxLocalKeys, defaultHotKeyMaxLocalKeys),
+		reads:        sketch.NewSpaceSaving[string](helpers.TernaryOp(cfg.Capacity > 0, cfg.Capacity, defaultHotKeyCapacity)),
+		lastDecay:    time.Now(),
+		pinned:       make(map[string]*pinnedKey),
+		hot:          make(map[string]struct{}),
+	}
+}
+
+// get returns the value of key if it is pinned in the local cache and has not expired.
+func (d *hotKeyDetector) get(key string) ([]byte, bool) {
+	d.mu.Lock()
+	p, ok := d.pinned[key]
+	if ok && time.Now().After(p.expiresAt) {
+		delete(d.pinned, key)
+		ok = false
+	}
+	if ok {
+		d.reads.Offer(key)
+	}
+	d.mu.Unlock()
+
+	if ok {
+		d.notify(HotKeyLocalHit, key, p.name)
+		return p.val, true
+	}
+	return nil, false
+}
+
+// observe records a read of key from the cache and pins it in the local cache if it is hot.
+func (d *hotKeyDetector) observe(key, name string, val []byte) {
+	now := time.Now()
+
+	d.mu.Lock()
+	if now.Sub(d.lastDecay) >= d.window {
+		d.decay(now)
+	}
+	c := d.reads.Offer(key)
+	detected := false
+	if c.Count-c.Error < d.threshold {
+		delete(d.hot, key)
+	} else if d.pin(key, name, val, now) {
+		_, wasHot := d.hot[key]
+		d.hot[key] = struct{}{}
+		detected = !wasHot
+	}
+	d.mu.Unlock()
+
+	if detected {
+		d.notify(HotKeyDetected, key, name)
+	}
+}
+
+// decay must be called with the lock held. It halves the read counts and forgets the keys that cooled down.
+func (d *hotKeyDetector) decay(now time.Time) {
+	d.reads.Decay()
+	d.lastDecay = now
+	for key := range d.hot {
+		if c, ok := d.reads.Get(key); !ok || c.Count-c.Error < d.threshold {
+			delete(d.hot, key)
+		}
+	}
+}
+
+// pin must be called with the lock held. It returns false if the local cache is full.
+func (d *hotKeyDetector) pin(key, name string, val []byte, now time.Time) bool {
+	if len(d.pinned) >= d.maxLocalKeys {
+		for k, p := range d.pinned {
+			if now.After(p.expiresAt) {
+				delete(d.pinned, k)
+			}
+		}
+		if len(d.pinned) >= d.maxLocalKeys {
+			return false
+		}
+	}
+	d.pinned[key] = &pinnedKey{val: val, name: name, expiresAt: now.Add(d.localTTL)}
+	return true
+}
+
+// unpin drops key from the local cache, so that a write is visible on the next read.
+func (d *hotKeyDetector) unpin(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pinned, key)
+}
+
+func (d *hotKeyDetector) stats(n int) []HotKeyStat {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	top := d.reads.Top(n)
+	stats := make([]HotKeyStat, 0, len(top))
+	for _, c := range top {
+		p, pinned := d.pinned[c.Item]
+		stats = append(stats, HotKeyStat{Key: c.Item, Reads: c.Count, Pinned: pinned && now.Before(p.expiresAt)})
+	}
+	return stats
+}
+
+func (d *hotKeyDetector) notify(event HotKeyEvent, key, name string) {
+	for _, listener := range d.listeners {
+		listener(event, key, name)
+	}
+}
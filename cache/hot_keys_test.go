@@ -0,0 +1,116 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestHotKeys(t *testing.T) {
+	counting := &countingCache{}
+	client, err := (&Config{
+		CacheProvider:       constants.CustomCacheType,
+		CustomConfiguration: &CustomConfig{Client: counting},
+		HotKeyConfig: &HotKeyConfig{
+			Threshold: 5,
+			Window:    time.Hour,
+			LocalTTL:  50 * time.Millisecond,
+		},
+	}).Freeze()
+	assert.NoError(t, err)
+
+	var detected, localHits int
+	client.OnHotKeyEvent(func(event HotKeyEvent, key, name string) {
+		assert.Equal(t, "hot", key)
+		assert.Equal(t, "rpcCallName", name)
+		switch event {
+		case HotKeyDetected:
+			detected++
+		case HotKeyLocalHit:
+			localHits++
+		}
+	})
+
+	ctx := WithOperationName(context.Background(), "rpcCallName")
+	for i := 0; i < 10; i++ {
+		_, err = client.Get(ctx, "hot")
+		assert.NoError(t, err)
+		_, err = client.Get(context.Background(), fmt.Sprintf("cold-%d", i))
+		assert.NoError(t, err)
+	}
+
+	// the hot key is only read from the cache until it is detected
+	assert.Equal(t, 5, counting.gets["hot"])
+	assert.Equal(t, 1, detected)
+	assert.Equal(t, 5, localHits)
+
+	stats := client.HotKeys(1)
+	assert.Equal(t, []HotKeyStat{{Key: "hot", Reads: 10, Pinned: true}}, stats)
+
+	// a write unpins the key
+	assert.NoError(t, client.Set(ctx, "hot", []byte("new"), time.Minute))
+	val, err := client.Get(ctx, "hot")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), val)
+	assert.Equal(t, 6, counting.gets["hot"])
+
+	// the local copy expires after the local TTL
+	time.Sleep(60 * time.Millisecond)
+	_, err = client.Get(ctx, "hot")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, counting.gets["hot"])
+
+	// pinning a key that is still hot again is not a new detection
+	assert.Equal(t, 1, detected)
+}
+
+type countingCache struct {
+	mu   sync.Mutex
+	gets map[string]int
+	data map[string][]byte
+}
+
+func (c *countingCache) Get(_ context.Context, key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gets == nil {
+		c.gets = map[string]int{}
+	}
+	c.gets[key]++
+	if val, ok := c.data[key]; ok {
+		return val, nil
+	}
+	return []byte(key), nil
+}
+
+func (c *countingCache) Set(_ context.Context, key string, val any, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.data == nil {
+		c.data = map[string][]byte{}
+	}
+	c.data[key] = val.([]byte)
+	return nil
+}
@@ -17,6 +17,8 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/pkg/errors"
 
 	"github.com/bytedance/heimdall/constants"
@@ -34,10 +36,45 @@ type Config struct {
 	// RedisConfiguration is a configuration for a redis cache client. This field is required only if CacheProvider is set to
 	// RedisCacheType.
 	RedisConfiguration *RedisConfig
+
+	// OperationTimeout bounds every Get and Set sent to the cache. A slow cache then fails fast and the caller
+	// falls back to the RPC instead of waiting for the cache client's own read timeout. This field is optional.
+	OperationTimeout time.Duration
+	// CircuitBreakerConfig enables a circuit breaker around the cache. After consecutive failures or timeouts, the
+	// cache is bypassed entirely until a probe succeeds. This field is optional.
+	CircuitBreakerConfig *CircuitBreakerConfig
+	// HotKeyConfig enables the detection of hot keys, which are then served from a small in-process cache for a short
+	// TTL instead of all being read from the same cache shard. This field is optional.
+	HotKeyConfig *HotKeyConfig
+	// ChunkingConfig enables storing values larger than the chunk size as several chunks plus a manifest, for caches
+	// that cap the size of their items. Reads reassemble and verify chunked values. This field is optional.
+	ChunkingConfig *ChunkingConfig
 }
 
 // Validate validates the cache configuration.
 func (c *Config) Validate() error {
+	if c.OperationTimeout < 0 {
+		return errors.Errorf("cache operation timeout cannot be negative")
+	}
+
+	if c.CircuitBreakerConfig != nil {
+		if err := c.CircuitBreakerConfig.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.HotKeyConfig != nil {
+		if err := c.HotKeyConfig.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.ChunkingConfig != nil {
+		if err := c.ChunkingConfig.Validate(); err != nil {
+			return err
+		}
+	}
+
 	switch c.CacheProvider {
 	case constants.CustomCacheType:
 		if c.CustomConfiguration == nil {
@@ -57,12 +94,33 @@ func (c *Config) Freeze() (*Client, error) {
 	if c == nil {
 		return nil, errors.Errorf("config, is nil")
 	}
+
+	var (
+		client *Client
+		err    error
+	)
 	switch c.CacheProvider {
 	case constants.CustomCacheType:
-		return newCustom(c.CustomConfiguration)
+		client, err = newCustom(c.CustomConfiguration)
 	case constants.RedisCacheType:
-		return newRedis(c.RedisConfiguration)
+		client, err = newRedis(c.RedisConfiguration)
 	default:
 		return nil, errors.Errorf("cache type %d is not supported", c.CacheProvider)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	client.timeout = c.OperationTimeout
+	if c.CircuitBreakerConfig != nil {
+		client.breaker = newCircuitBreaker(c.CircuitBreakerConfig)
+	}
+	if c.HotKeyConfig != nil {
+		client.hotKeys = newHotKeyDetector(c.HotKeyConfig)
+	}
+	if c.ChunkingConfig != nil {
+		client.chunkSize = c.ChunkingConfig.ChunkSize
+		client.maxChunks = c.ChunkingConfig.MaxChunks
+	}
+	return client, nil
 }
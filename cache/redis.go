@@ -93,9 +93,21 @@ type wrappedRedisClient struct {
 }
 
 func (c *wrappedRedisClient) Get(ctx context.Context, key string) ([]byte, error) {
-	return c.client.Get(ctx, key).Bytes()
+	val, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return val, err
 }
 
 func (c *wrappedRedisClient) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
 	return c.client.Set(ctx, key, val, ttl).Err()
 }
+
+func (c *wrappedRedisClient) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *wrappedRedisClient) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sketch
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// CountMin estimates the frequency of strings in a stream with a Count-Min sketch of depth rows of width counters.
+// Estimates never undercount, and overcount by at most a few occurrences of other strings. It is not safe for
+// concurrent use.
+type CountMin struct {
+	width    uint64
+	counters [][]uint32
+}
+
+// NewCountMin returns a new CountMin sketch. It panics if width or depth is not positive.
+func NewCountMin(width, depth int) *CountMin {
+	if width <= 0 || depth <= 0 {
+		panic("count min width and depth must be positive")
+	}
+	counters := make([][]uint32, depth)
+	for i := range counters {
+		counters[i] = make([]uint32, width)
+	}
+	return &CountMin{width: uint64(width), counters: counters}
+}
+
+// Add records an occurrence of item and returns its estimated count. Only the smallest counters of the item are
+// increased (conservative update), which reduces overcounting.
+func (s *CountMin) Add(item string) uint32 {
+	h1, h2 := hashes(item)
+	estimate := s.estimate(h1, h2)
+	if estimate == math.MaxUint32 {
+		return estimate
+	}
+
+	for i, row := range s.counters {
+		idx := s.index(h1, h2, i)
+		if row[idx] == estimate {
+			row[idx]++
+		}
+	}
+	return estimate + 1
+}
+
+// Estimate returns the estimated count of item.
+func (s *CountMin) Estimate(item string) uint32 {
+	h1, h2 := hashes(item)
+	return s.estimate(h1, h2)
+}
+
+// Decay halves every counter, so that the sketch favours recent occurrences.
+func (s *CountMin) Decay() {
+	for _, row := range s.counters {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+}
+
+// Reset sets every counter to 0.
+func (s *CountMin) Reset() {
+	for _, row := range s.counters {
+		for i := range row {
+			row[i] = 0
+		}
+	}
+}
+
+func (s *CountMin) estimate(h1, h2 uint64) uint32 {
+	estimate := uint32(math.MaxUint32)
+	for i, row := range s.counters {
+		if c := row[s.index(h1, h2, i)]; c < estimate {
+			estimate = c
+		}
+	}
+	return estimate
+}
+
+// index derives the counter of the item in a row from two hashes (Kirsch-Mitzenmacher double hashing).
+func (s *CountMin) index(h1, h2 uint64, row int) uint64 {
+	return (h1 + uint64(row)*h2) % s.width
+}
+
+func hashes(item string) (uint64, uint64) {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(item))
+	h := hasher.Sum64()
+	return h, h>>32 | 1
+}
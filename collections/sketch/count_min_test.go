@@ -0,0 +1,46 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sketch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountMin(t *testing.T) {
+	s := NewCountMin(1024, 4)
+	for i := 0; i < 100; i++ {
+		s.Add("frequent")
+		s.Add(fmt.Sprintf("rare-%d", i))
+	}
+
+	assert.GreaterOrEqual(t, s.Estimate("frequent"), uint32(100))
+	assert.Less(t, s.Estimate("frequent"), uint32(110))
+	assert.GreaterOrEqual(t, s.Estimate("rare-1"), uint32(1))
+	assert.Less(t, s.Estimate("rare-1"), uint32(5))
+	assert.Equal(t, uint32(0), s.Estimate("unseen"))
+
+	s.Decay()
+	assert.GreaterOrEqual(t, s.Estimate("frequent"), uint32(50))
+	assert.Less(t, s.Estimate("frequent"), uint32(55))
+
+	s.Reset()
+	assert.Equal(t, uint32(0), s.Estimate("frequent"))
+	assert.Equal(t, uint32(1), s.Add("frequent"))
+}
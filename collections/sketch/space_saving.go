@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Counter is an item monitored by a SpaceSaving sketch. Its true count is in the range [Count-Error, Count].
+type Counter[T comparable] struct {
+	Item  T
+	Count uint64
+	Error uint64
+}
+
+// SpaceSaving finds the heavy hitters of a stream with the Space-Saving algorithm. It monitors at most capacity items,
+// and any item occurring more than 1/capacity of the time is guaranteed to be monitored. It is not safe for concurrent use.
+type SpaceSaving[T comparable] struct {
+	capacity int
+	counters counterHeap[T]
+	index    map[T]*counter[T]
+}
+
+type counter[T comparable] struct {
+	Counter[T]
+	pos int
+}
+
+// NewSpaceSaving returns a new SpaceSaving sketch monitoring at most capacity items. It panics if capacity is not positive.
+func NewSpaceSaving[T comparable](capacity int) *SpaceSaving[T] {
+	if capacity <= 0 {
+		panic("space saving capacity must be positive")
+	}
+	return &SpaceSaving[T]{
+		capacity: capacity,
+		counters: make(counterHeap[T], 0, capacity),
+		index:    make(map[T]*counter[T], capacity),
+	}
+}
+
+// Offer records an occurrence of item and returns its counter. If the sketch is full, the item with the smallest
+// count is replaced and its count becomes the error of the new item.
+func (s *SpaceSaving[T]) Offer(item T) Counter[T] {
+	if c, ok := s.index[item]; ok {
+		c.Count++
+		heap.Fix(&s.counters, c.pos)
+		return c.Counter
+	}
+
+	if len(s.counters) < s.capacity {
+		c := &counter[T]{Counter: Counter[T]{Item: item, Count: 1}}
+		heap.Push(&s.counters, c)
+		s.index[item] = c
+		return c.Counter
+	}
+
+	c := s.counters[0]
+	delete(s.index, c.Item)
+	c.Item, c.Error = item, c.Count
+	c.Count++
+	heap.Fix(&s.counters, 0)
+	s.index[item] = c
+	return c.Counter
+}
+
+// Get returns the counter of item, and false if the item is not monitored.
+func (s *SpaceSaving[T]) Get(item T) (Counter[T], bool) {
+	c, ok := s.index[item]
+	if !ok {
+		return Counter[T]{}, false
+	}
+	return c.Counter, true
+}
+
+// Top returns the n monitored items with the highest counts, highest first.
+func (s *SpaceSaving[T]) Top(n int) []Counter[T] {
+	top := make([]Counter[T], 0, len(s.counters))
+	for _, c := range s.counters {
+		top = append(top, c.Counter)
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Count > top[j].Count })
+	if n < len(top) {
+		top = top[:n]
+	}
+	return top
+}
+
+// Decay halves every count, so that the sketch favours recent occurrences. Items whose count drops to 0 are forgotten.
+func (s *SpaceSaving[T]) Decay() {
+	kept := s.counters[:0]
+	for _, c := range s.counters {
+		c.Count /= 2
+		c.Error /= 2
+		if c.Count == 0 {
+			delete(s.index, c.Item)
+			continue
+		}
+		c.pos = len(kept)
+		kept = append(kept, c)
+	}
+	for i := len(kept); i < len(s.counters); i++ {
+		s.counters[i] = nil
+	}
+	s.counters = kept
+	heap.Init(&s.counters)
+}
+
+// Len returns the number of monitored items.
+func (s *SpaceSaving[T]) Len() int {
+	return len(s.counters)
+}
+
+// counterHeap is a min-heap of counters by count.
+type counterHeap[T comparable] []*counter[T]
+
+func (h counterHeap[T]) Len() int           { return len(h) }
+func (h counterHeap[T]) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h counterHeap[T]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+
+func (h *counterHeap[T]) Push(x any) {
+	c := x.(*counter[T])
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap[T]) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sketch
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpaceSavingHeavyHitters(t *testing.T) {
+	s := NewSpaceSaving[string](10)
+	for i := 0; i < 1000; i++ {
+		s.Offer("hot")
+		if i%2 == 0 {
+			s.Offer("warm")
+		}
+		s.Offer(fmt.Sprintf("cold-%d", i))
+	}
+
+	top := s.Top(2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "hot", top[0].Item)
+	assert.Equal(t, "warm", top[1].Item)
+	assert.GreaterOrEqual(t, top[0].Count, uint64(1000))
+	assert.LessOrEqual(t, top[0].Count-top[0].Error, uint64(1000))
+	assert.Equal(t, 10, s.Len())
+}
+
+func TestSpaceSavingReplacesMinimum(t *testing.T) {
+	s := NewSpaceSaving[string](2)
+	s.Offer("a")
+	s.Offer("a")
+	s.Offer("b")
+
+	c := s.Offer("c")
+	assert.Equal(t, Counter[string]{Item: "c", Count: 2, Error: 1}, c)
+	_, ok := s.Get("b")
+	assert.False(t, ok)
+	c, ok = s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), c.Count)
+}
+
+func TestSpaceSavingDecay(t *testing.T) {
+	s := NewSpaceSaving[string](4)
+	for i := 0; i < 4; i++ {
+		s.Offer("a")
+	}
+	s.Offer("b")
+
+	s.Decay()
+	c, ok := s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), c.Count)
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+	assert.Equal(t, 1, s.Len())
+
+	s.Offer("b")
+	s.Offer("a")
+	assert.Equal(t, "a", s.Top(1)[0].Item)
+}
@@ -17,45 +17,76 @@
 package heimdall
 
 import (
-	"bytes"
 	"compress/gzip"
 	"context"
+	"sync"
 
+	"github.com/andybalholm/brotli"
 	"github.com/bytedance/heimdall/constants"
 	json "github.com/bytedance/sonic"
 	"github.com/golang/snappy"
+	"github.com/pierrec/lz4/v4"
 	"github.com/pkg/errors"
 )
 
 const (
-	defaultCompressionLevel = gzip.DefaultCompression
+	defaultCompressionLevel       = gzip.DefaultCompression
+	defaultBrotliCompressionLevel = brotli.DefaultCompression
 )
 
-// CompressStruct converts a struct to JSON and compresses it according to chosen compression library.
+// CompressStruct converts a struct to JSON and compresses it with the compressor registered for the chosen compression
+// library.
 func CompressStruct(ctx context.Context, s any, compressionLibrary constants.CompressionLibraryType) ([]byte, error) {
+	return compressStruct(ctx, s, compressionLibrary, "")
+}
+
+// compressStruct is CompressStruct using the zstd dictionary of rpcCallName, if there is one.
+func compressStruct(ctx context.Context, s any, compressionLibrary constants.CompressionLibraryType, rpcCallName string) ([]byte, error) {
 	b, err := json.Marshal(s)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to marshal struct")
 	}
-	switch compressionLibrary {
-	case constants.GzipCompressionType:
-		return gzipCompression(b)
-	case constants.SnappyCompressionType:
-		return snappyCompression(b)
-	default:
-		return b, nil
-	}
+	return compressBytes(ctx, b, compressionLibrary, rpcCallName)
+}
+
+var (
+	gzipWriterPool   = sync.Pool{New: func() any { w, _ := gzip.NewWriterLevel(nil, defaultCompressionLevel); return w }}
+	snappyWriterPool = sync.Pool{New: func() any { return snappy.NewBufferedWriter(nil) }}
+	lz4WriterPool    = sync.Pool{New: func() any { return lz4.NewWriter(nil) }}
+	brotliWriterPool = sync.Pool{New: func() any { return brotli.NewWriterLevel(nil, defaultBrotliCompressionLevel) }}
+)
+
+// The put functions reset pooled writers to no destination, so that the pool does not keep the last buffer written to
+// alive.
+func putGzipWriter(writer *gzip.Writer) {
+	writer.Reset(nil)
+	gzipWriterPool.Put(writer)
+}
+
+func putSnappyWriter(writer *snappy.Writer) {
+	writer.Reset(nil)
+	snappyWriterPool.Put(writer)
+}
+
+func putLZ4Writer(writer *lz4.Writer) {
+	writer.Reset(nil)
+	lz4WriterPool.Put(writer)
+}
+
+func putBrotliWriter(writer *brotli.Writer) {
+	writer.Reset(nil)
+	brotliWriterPool.Put(writer)
 }
 
 func gzipCompression(data []byte) ([]byte, error) {
-	var buffer bytes.Buffer
+	buffer := getBuffer()
+	defer putBuffer(buffer)
 
-	writer, err := gzip.NewWriterLevel(&buffer, defaultCompressionLevel)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot create new gzip writer")
-	}
+	writer := gzipWriterPool.Get().(*gzip.Writer)
+	defer putGzipWriter(writer)
+	writer.Reset(buffer)
 
-	_, err = writer.Write(data)
+	_, err := writer.Write(data)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot write to gzip")
 	}
@@ -65,16 +96,18 @@ func gzipCompression(data []byte) ([]byte, error) {
 		return nil, errors.Wrap(err, "cannot close writer")
 	}
 
-	return buffer.Bytes(), nil
+	return copyBuffer(buffer), nil
 }
 
 func snappyCompression(data []byte) ([]byte, error) {
-	var buffer bytes.Buffer
+	buffer := getBuffer()
+	defer putBuffer(buffer)
 
-	writer := snappy.NewBufferedWriter(&buffer)
+	writer := snappyWriterPool.Get().(*snappy.Writer)
+	defer putSnappyWriter(writer)
+	writer.Reset(buffer)
 
 	_, err := writer.Write(data)
-
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot write to snappy")
 	}
@@ -84,5 +117,47 @@ func snappyCompression(data []byte) ([]byte, error) {
 		return nil, errors.Wrap(err, "cannot close writer")
 	}
 
-	return buffer.Bytes(), nil
+	return copyBuffer(buffer), nil
+}
+
+func lz4Compression(data []byte) ([]byte, error) {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	writer := lz4WriterPool.Get().(*lz4.Writer)
+	defer putLZ4Writer(writer)
+	writer.Reset(buffer)
+
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot write to lz4")
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot close writer")
+	}
+
+	return copyBuffer(buffer), nil
+}
+
+func brotliCompression(data []byte) ([]byte, error) {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	writer := brotliWriterPool.Get().(*brotli.Writer)
+	defer putBrotliWriter(writer)
+	writer.Reset(buffer)
+
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot write to brotli")
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot close writer")
+	}
+
+	return copyBuffer(buffer), nil
 }
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const defaultCompressionMinSize = 256
+
+var compressionPolicy *CompressionPolicy
+
+// CompressionPolicy chooses the compression library of every entry by its size. Entries are written with a header
+// recording the library used, so that they are read back correctly whatever the policy or CompressionLibrary is later.
+// Zero values fall back to the defaults.
+type CompressionPolicy struct {
+	// MinSize is the size in bytes of the JSON encoded entry below which it is stored uncompressed, as compressing
+	// small entries costs CPU and often makes them larger. Defaults to 256.
+	MinSize int `json:"min_size,omitempty" yaml:"min_size,omitempty" xml:"min_size,omitempty"`
+	// Tiers override CompressionLibrary for entries of at least their MinSize, for example a fast library for medium
+	// entries and a denser one for large entries. The tier with the largest MinSize not above the entry size is used.
+	// This field is optional.
+	Tiers []CompressionTier `json:"tiers,omitempty" yaml:"tiers,omitempty" xml:"tiers,omitempty"`
+}
+
+// CompressionTier is the compression library of the entries of at least MinSize bytes.
+type CompressionTier struct {
+	// MinSize is the minimum size in bytes of the JSON encoded entries of the tier.
+	MinSize int `json:"min_size,omitempty" yaml:"min_size,omitempty" xml:"min_size,omitempty"`
+	// CompressionLibrary is the compression library of the tier.
+	CompressionLibrary constants.CompressionLibraryType `json:"compress_library,omitempty" yaml:"compress_library,omitempty" xml:"compress_library,omitempty"`
+}
+
+func (c *CompressionPolicy) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MinSize < 0 {
+		return errors.Errorf("compression policy cannot have negative values")
+	}
+	for _, tier := range c.Tiers {
+		if tier.MinSize < 0 {
+			return errors.Errorf("compression policy cannot have negative values")
+		}
+		if _, err := getCompressor(tier.CompressionLibrary); err != nil {
+			return errors.Wrapf(err, "invalid compression library for the tier of %d bytes", tier.MinSize)
+		}
+	}
+	return nil
+}
+
+// InjectCompressionPolicy sets the compression policy. A nil policy compresses every entry with CompressionLibrary and
+// writes entries in the legacy format, without a header.
+func InjectCompressionPolicy(p *CompressionPolicy) {
+	if p == nil {
+		compressionPolicy = nil
+		return
+	}
+
+	tiers := append([]CompressionTier(nil), p.Tiers...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].MinSize < tiers[j].MinSize })
+	compressionPolicy = &CompressionPolicy{
+		MinSize: helpers.TernaryOp(p.MinSize > 0, p.MinSize, defaultCompressionMinSize),
+		Tiers:   tiers,
+	}
+}
+
+// chooseCompressionLibrary returns the compression library of an entry of size bytes.
+func chooseCompressionLibrary(size int) constants.CompressionLibraryType {
+	p := compressionPolicy
+	if p == nil {
+		return compressionLibrary
+	}
+	if size < p.MinSize {
+		return constants.NoCompressionType
+	}
+
+	library := compressionLibrary
+	for _, tier := range p.Tiers {
+		if size < tier.MinSize {
+			break
+		}
+		library = tier.CompressionLibrary
+	}
+	return library
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/metrics"
+)
+
+func TestCompressionPolicy(t *testing.T) {
+	policy := &CompressionPolicy{
+		MinSize: 100,
+		Tiers: []CompressionTier{
+			{MinSize: 10000, CompressionLibrary: constants.ZstdCompressionType},
+			{MinSize: 1000, CompressionLibrary: constants.SnappyCompressionType},
+		},
+	}
+	tests := []struct {
+		name  string
+		size  int
+		codec constants.CompressionLibraryType
+	}{
+		{
+			name:  "small entry stored raw",
+			size:  10,
+			codec: constants.NoCompressionType,
+		}, {
+			name:  "default library",
+			size:  500,
+			codec: constants.GzipCompressionType,
+		}, {
+			name:  "medium tier",
+			size:  5000,
+			codec: constants.SnappyCompressionType,
+		}, {
+			name:  "large tier",
+			size:  50000,
+			codec: constants.ZstdCompressionType,
+		},
+	}
+
+	InjectCompressionLibrary(constants.GzipCompressionType)
+	InjectCompressionPolicy(policy)
+	t.Cleanup(func() {
+		InjectCompressionLibrary(constants.NoCompressionType)
+		InjectCompressionPolicy(nil)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]any{}
+			mockCache(data)
+
+			ctx := context.Background()
+			cacheVal := &CacheValue{UpdatedTS: time.Now().Unix(), Data: strings.Repeat("x", tt.size)}
+			assert.NoError(t, setCacheValue(ctx, "key", cacheVal, time.Minute, "rpcCallName"))
+
+			e, ok, err := unmarshalEnvelope(data["key"].([]byte))
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.codec, e.codec)
+
+			got, err := fetchFromCache(ctx, "key", "rpcCallName")
+			assert.NoError(t, err)
+			assert.Equal(t, cacheVal, got)
+		})
+	}
+}
+
+func TestCompressionPolicyLegacyEntry(t *testing.T) {
+	data := map[string]any{}
+	mockCache(data)
+	InjectCompressionLibrary(constants.GzipCompressionType)
+	t.Cleanup(func() {
+		InjectCompressionLibrary(constants.NoCompressionType)
+		InjectCompressionPolicy(nil)
+	})
+
+	ctx := context.Background()
+	cacheVal := &CacheValue{UpdatedTS: time.Now().Unix(), Data: strings.Repeat("x", 500)}
+	assert.NoError(t, setCacheValue(ctx, "key", cacheVal, time.Minute, "rpcCallName"))
+	_, ok, _ := unmarshalEnvelope(data["key"].([]byte))
+	assert.False(t, ok)
+
+	InjectCompressionPolicy(&CompressionPolicy{})
+	got, err := fetchFromCache(ctx, "key", "rpcCallName")
+	assert.NoError(t, err)
+	assert.Equal(t, cacheVal, got)
+}
+
+func TestCompressionMetrics(t *testing.T) {
+	data := map[string]any{}
+	mockCache(data)
+	recorder := &compressionMetrics{}
+	InjectMetricsProvider(&metrics.Client{IncreaseMetricAPI: recorder})
+	InjectCompressionLibrary(constants.GzipCompressionType)
+	InjectCompressionPolicy(&CompressionPolicy{MinSize: 100})
+	t.Cleanup(func() {
+		InjectMetricsProvider(nil)
+		InjectCompressionLibrary(constants.NoCompressionType)
+		InjectCompressionPolicy(nil)
+	})
+
+	ctx := context.Background()
+	// entries stored uncompressed emit no compression metrics
+	assert.NoError(t, setCacheValue(ctx, "small", &CacheValue{Data: "x"}, time.Minute, "rpcCallName"))
+	_, err := fetchFromCache(ctx, "small", "rpcCallName")
+	assert.NoError(t, err)
+	assert.Empty(t, recorder.compressed)
+	assert.Empty(t, recorder.decompressed)
+
+	assert.NoError(t, setCacheValue(ctx, "large", &CacheValue{Data: strings.Repeat("x", 500)}, time.Minute, "rpcCallName"))
+	_, err = fetchFromCache(ctx, "large", "rpcCallName")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rpcCallName"}, recorder.compressed)
+	assert.Equal(t, []string{"rpcCallName"}, recorder.decompressed)
+}
+
+type compressionMetrics struct {
+	closableMetrics
+	compressed, decompressed []string
+}
+
+func (m *compressionMetrics) EmitCompressionMetric(ctx context.Context, metricName string, library string, ratio float64, duration time.Duration) {
+	m.compressed = append(m.compressed, metricName)
+}
+
+func (m *compressionMetrics) EmitDecompressionMetric(ctx context.Context, metricName string, library string, duration time.Duration) {
+	m.decompressed = append(m.decompressed, metricName)
+}
+
+func TestCompressionPolicyValidate(t *testing.T) {
+	assert.NoError(t, (*CompressionPolicy)(nil).validate())
+	assert.NoError(t, (&CompressionPolicy{Tiers: []CompressionTier{{MinSize: 10, CompressionLibrary: constants.LZ4CompressionType}}}).validate())
+	assert.Error(t, (&CompressionPolicy{MinSize: -1}).validate())
+	assert.Error(t, (&CompressionPolicy{Tiers: []CompressionTier{{MinSize: 10, CompressionLibrary: 99}}}).validate())
+}
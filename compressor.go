@@ -0,0 +1,117 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+// Compressor compresses the JSON encoded cache entries. Built-in compressors are registered for every
+// CompressionLibraryType constant; custom compressors are registered with RegisterCompressor.
+// Compressors must be safe for concurrent use.
+type Compressor interface {
+	// Compress compresses data.
+	Compress(data []byte) ([]byte, error)
+	// Decompress decompresses data compressed by Compress.
+	Decompress(data []byte) ([]byte, error)
+	// ID is the value of Config.CompressionLibrary that selects the compressor.
+	ID() constants.CompressionLibraryType
+}
+
+// methodCompressor is implemented by compressors that compress differently per rpc call name, such as zstd with
+// per-method dictionaries.
+type methodCompressor interface {
+	compressMethod(data []byte, rpcCallName string) ([]byte, error)
+}
+
+var compressors sync.Map // constants.CompressionLibraryType -> Compressor
+
+func init() {
+	for _, c := range []Compressor{
+		noCompressor{}, gzipCompressor{}, snappyCompressor{}, zstdCompressor{}, lz4Compressor{}, brotliCompressor{},
+	} {
+		compressors.Store(c.ID(), c)
+	}
+}
+
+// RegisterCompressor registers a custom compressor, which is selected by setting Config.CompressionLibrary to its ID.
+// Custom compressors should use ids from constants.CustomCompressionTypeStart to 255, so that they do not collide
+// with compressors added to Heimdall later. Register compressors at startup, before Init.
+func RegisterCompressor(c Compressor) error {
+	if c == nil {
+		return errors.Errorf("compressor cannot be nil")
+	}
+	if c.ID() < 0 || c.ID() > maxEnvelopeCodec {
+		return errors.Errorf("compressor id must be in the range [0, %d]", maxEnvelopeCodec)
+	}
+	if _, loaded := compressors.LoadOrStore(c.ID(), c); loaded {
+		return errors.Errorf("compressor with id %d is already registered", c.ID())
+	}
+	return nil
+}
+
+func getCompressor(id constants.CompressionLibraryType) (Compressor, error) {
+	c, ok := compressors.Load(id)
+	if !ok {
+		return nil, errors.Errorf("compression library %d is not registered", id)
+	}
+	return c.(Compressor), nil
+}
+
+type noCompressor struct{}
+
+func (noCompressor) Compress(data []byte) ([]byte, error)   { return data, nil }
+func (noCompressor) Decompress(data []byte) ([]byte, error) { return data, nil }
+func (noCompressor) ID() constants.CompressionLibraryType   { return constants.NoCompressionType }
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error)   { return gzipCompression(data) }
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) { return gzipDecompression(data) }
+func (gzipCompressor) ID() constants.CompressionLibraryType   { return constants.GzipCompressionType }
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error)   { return snappyCompression(data) }
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) { return snappyDecompression(data) }
+func (snappyCompressor) ID() constants.CompressionLibraryType   { return constants.SnappyCompressionType }
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error)   { return zstdCompression(data, "") }
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) { return zstdDecompression(data) }
+func (zstdCompressor) ID() constants.CompressionLibraryType   { return constants.ZstdCompressionType }
+
+func (zstdCompressor) compressMethod(data []byte, rpcCallName string) ([]byte, error) {
+	return zstdCompression(data, rpcCallName)
+}
+
+type lz4Compressor struct{}
+
+func (lz4Compressor) Compress(data []byte) ([]byte, error)   { return lz4Compression(data) }
+func (lz4Compressor) Decompress(data []byte) ([]byte, error) { return lz4Decompression(data) }
+func (lz4Compressor) ID() constants.CompressionLibraryType   { return constants.LZ4CompressionType }
+
+type brotliCompressor struct{}
+
+func (brotliCompressor) Compress(data []byte) ([]byte, error)   { return brotliCompression(data) }
+func (brotliCompressor) Decompress(data []byte) ([]byte, error) { return brotliDecompression(data) }
+func (brotliCompressor) ID() constants.CompressionLibraryType   { return constants.BrotliCompressionType }
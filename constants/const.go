@@ -16,7 +16,11 @@
 
 package constants
 
-import "github.com/bytedance/heimdall/collections/set"
+import (
+	"strconv"
+
+	"github.com/bytedance/heimdall/collections/set"
+)
 
 // CacheType is the type of cache to use for Heimdall
 type CacheType int32
@@ -54,4 +58,85 @@ const (
 	GzipCompressionType
 	// SnappyCompression will enable compression and values are compressed with the Snappy library and stored in the cache.
 	SnappyCompressionType
+	// ZstdCompressionType will enable compression and values are compressed with Zstandard, optionally with a
+	// pre-trained dictionary per rpc call name, and stored in the cache.
+	ZstdCompressionType
+	// LZ4CompressionType will enable compression and values are compressed with the LZ4 library and stored in the cache.
+	LZ4CompressionType
+	// BrotliCompressionType will enable compression and values are compressed with the Brotli library and stored in the cache.
+	BrotliCompressionType
+)
+
+// String returns the name of the compression library, as used in metrics.
+func (t CompressionLibraryType) String() string {
+	switch t {
+	case NoCompressionType:
+		return "none"
+	case GzipCompressionType:
+		return "gzip"
+	case SnappyCompressionType:
+		return "snappy"
+	case ZstdCompressionType:
+		return "zstd"
+	case LZ4CompressionType:
+		return "lz4"
+	case BrotliCompressionType:
+		return "brotli"
+	default:
+		return "custom_" + strconv.Itoa(int(t))
+	}
+}
+
+// CustomCompressionTypeStart is the first compression library type reserved for compressors registered with
+// heimdall.RegisterCompressor.
+const CustomCompressionTypeStart CompressionLibraryType = 100
+
+// DropPolicyType is the policy used by the background worker pool when its queue is full.
+type DropPolicyType int32
+
+const (
+	// DropOldestPolicy discards the oldest queued task to make room for the newly submitted task.
+	DropOldestPolicy DropPolicyType = iota
+	// DropNewestPolicy discards the newly submitted task and keeps the queue as it is.
+	DropNewestPolicy
+	// RunInlinePolicy runs the newly submitted task on the caller's goroutine. This applies back-pressure to the caller.
+	RunInlinePolicy
+)
+
+// LimitPolicyType is the behaviour of Heimdall when a call to the downstream service is over its concurrency or rate limit.
+type LimitPolicyType int32
+
+const (
+	// WaitLimitPolicy waits for the limiter to admit the call, up to the configured wait timeout.
+	WaitLimitPolicy LimitPolicyType = iota
+	// StaleLimitPolicy serves the stale cache entry if there is one and skips the refresh. Cache misses fail fast.
+	StaleLimitPolicy
+	// FailFastLimitPolicy fails cache misses immediately with heimdall.ErrDownstreamLimited. Soft TTL hits serve the stale
+	// entry, and the skipped refresh is reported to the error hook.
+	FailFastLimitPolicy
+)
+
+// OversizePolicyType is the behaviour of Heimdall when a compressed cache entry is larger than the maximum entry size.
+type OversizePolicyType int32
+
+const (
+	// SkipOversizePolicy does not cache the entry. The response is still returned to the caller.
+	SkipOversizePolicy OversizePolicyType = iota
+	// ChunkOversizePolicy splits the entry into chunks stored under derived keys, which are reassembled on read.
+	ChunkOversizePolicy
+)
+
+// KeyHasherType is the hash function used to generate cache keys from their key material.
+type KeyHasherType int32
+
+const (
+	// SHA512KeyHasher hashes keys with SHA512, encoded in URL safe base64 with padding (88 characters).
+	SHA512KeyHasher KeyHasherType = iota
+	// SHA256KeyHasher hashes keys with SHA256, encoded in URL safe base64 without padding (43 characters).
+	SHA256KeyHasher
+	// XXH3KeyHasher hashes keys with the 128 bits variant of the non-cryptographic XXH3, encoded in URL safe base64
+	// without padding (22 characters). It is the fastest, but keys can be forged by whoever controls the requests.
+	XXH3KeyHasher
+	// BLAKE3KeyHasher hashes keys with the 256 bits BLAKE3, encoded in URL safe base64 without padding (43 characters).
+	BLAKE3KeyHasher
 )
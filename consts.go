@@ -17,10 +17,12 @@
 package heimdall
 
 import (
+	"context"
 	"time"
 
 	"github.com/bytedance/heimdall/cache"
 	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
 	"github.com/bytedance/heimdall/metrics"
 )
 
@@ -36,8 +38,22 @@ var (
 	compressionLibrary constants.CompressionLibraryType
 
 	version string
+
+	refreshTimeout = defaultRefreshTimeout
+	writeTimeout   = defaultWriteTimeout
+
+	errorHook ErrorHook
+)
+
+const (
+	defaultRefreshTimeout = 10 * time.Second
+	defaultWriteTimeout   = 2 * time.Second
 )
 
+// ErrorHook is called with errors that happen in the background, such as failed soft TTL refreshes and cache writes,
+// as these cannot be returned to the caller.
+type ErrorHook func(ctx context.Context, rpcCallName string, err error)
+
 func InjectCacheProvider(c *cache.Client) {
 	cacheProvider = c
 }
@@ -62,3 +78,12 @@ func InjectCompressionLibrary(t constants.CompressionLibraryType) {
 func InjectVersion(v string) {
 	version = v
 }
+func InjectRefreshTimeout(t time.Duration) {
+	refreshTimeout = helpers.TernaryOp(t > 0, t, defaultRefreshTimeout)
+}
+func InjectWriteTimeout(t time.Duration) {
+	writeTimeout = helpers.TernaryOp(t > 0, t, defaultWriteTimeout)
+}
+func InjectErrorHook(h ErrorHook) {
+	errorHook = h
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"time"
+)
+
+// detachedContext keeps the values of its parent (trace ids, gRPC metadata etc.) but not its deadline or cancellation.
+// Background work outlives the request that triggered it, so it must not be cancelled when the request returns.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key any) any {
+	return c.parent.Value(key)
+}
+
+func detachContext(ctx context.Context) context.Context {
+	if _, ok := ctx.(detachedContext); ok {
+		return ctx
+	}
+	return detachedContext{parent: ctx}
+}
+
+// backgroundContext detaches ctx from its parent's cancellation and bounds it with timeout, if one is set.
+func backgroundContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx = detachContext(ctx)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+type warmUpKey struct{}
+
+// withWarmUp marks ctx as belonging to a warm-up call made by Warm.
+func withWarmUp(ctx context.Context) context.Context {
+	return context.WithValue(ctx, warmUpKey{}, true)
+}
+
+func isWarmUp(ctx context.Context) bool {
+	warmUp, _ := ctx.Value(warmUpKey{}).(bool)
+	return warmUp
+}
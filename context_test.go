@@ -0,0 +1,45 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testContextKey struct{}
+
+func TestBackgroundContext(t *testing.T) {
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey{}, "trace-id"))
+	cancel()
+
+	ctx, cancelBg := backgroundContext(parent, 0)
+	defer cancelBg()
+	assert.NoError(t, ctx.Err())
+	assert.Equal(t, "trace-id", ctx.Value(testContextKey{}))
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+
+	ctx, cancelBg = backgroundContext(parent, time.Millisecond)
+	defer cancelBg()
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	assert.Equal(t, "trace-id", ctx.Value(testContextKey{}))
+}
@@ -20,57 +20,114 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"io/ioutil"
+	"io"
+	"sync"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/bytedance/heimdall/constants"
 	json "github.com/bytedance/sonic"
 	"github.com/golang/snappy"
+	"github.com/pierrec/lz4/v4"
 	"github.com/pkg/errors"
 )
 
-// DecompressStruct decompresses GZIP compressed data and unmarshal it to the target struct. Note: target struct must be a pointer.
+// DecompressStruct decompresses data with the compressor registered for the chosen compression library and unmarshal
+// it to the target struct. Note: target struct must be a pointer.
 func DecompressStruct(ctx context.Context, data []byte, targetStruct any, compressionLibrary constants.CompressionLibraryType) error {
-	var err error
-	switch compressionLibrary {
-	case constants.GzipCompressionType:
-		b, err := gzipDecompression(data)
-		if err != nil {
-			return err
-		}
-		err = json.Unmarshal(b, targetStruct)
-		if err != nil {
-			return errors.Wrap(err, "unable to unmarshal for struct decompression")
-		}
-	case constants.SnappyCompressionType:
-		b, err := snappyDecompression(data)
-		if err != nil {
-			return err
-		}
-		err = json.Unmarshal(b, targetStruct)
-		if err != nil {
-			return errors.Wrap(err, "unable to unmarshal for struct decompression")
-		}
-	default:
-		err = json.Unmarshal(data, targetStruct)
-		if err != nil {
-			return errors.Wrap(err, "unable to unmarshal for struct decompression")
-		}
+	return decompressStruct(ctx, data, targetStruct, compressionLibrary, "")
+}
+
+// decompressStruct is DecompressStruct emitting the decompression metric for rpcCallName.
+func decompressStruct(ctx context.Context, data []byte, targetStruct any, compressionLibrary constants.CompressionLibraryType, rpcCallName string) error {
+	compressor, err := getCompressor(compressionLibrary)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	b, err := compressor.Decompress(data)
+	if err != nil {
+		return err
+	}
+	if !isSkipMetrics() && compressionLibrary != constants.NoCompressionType {
+		metricsProvider.EmitDecompressionMetric(ctx, rpcCallName, compressionLibrary.String(), time.Since(start))
+	}
+
+	err = json.Unmarshal(b, targetStruct)
+	if err != nil {
+		return errors.Wrap(err, "unable to unmarshal for struct decompression")
 	}
 
 	return nil
 }
 
+var (
+	gzipReaderPool   sync.Pool // *gzip.Reader, which cannot be created without a valid header
+	snappyReaderPool = sync.Pool{New: func() any { return snappy.NewReader(nil) }}
+	lz4ReaderPool    = sync.Pool{New: func() any { return lz4.NewReader(nil) }}
+	brotliReaderPool = sync.Pool{New: func() any { return brotli.NewReader(nil) }}
+)
+
+// The put functions reset pooled readers to an empty source, so that the pool does not keep the last entry read alive.
+func putGzipReader(reader *gzip.Reader) {
+	_ = reader.Reset(emptyReader{})
+	gzipReaderPool.Put(reader)
+}
+
+func putSnappyReader(reader *snappy.Reader) {
+	reader.Reset(nil)
+	snappyReaderPool.Put(reader)
+}
+
+func putLZ4Reader(reader *lz4.Reader) {
+	reader.Reset(nil)
+	lz4ReaderPool.Put(reader)
+}
+
+func putBrotliReader(reader *brotli.Reader) {
+	_ = reader.Reset(nil)
+	brotliReaderPool.Put(reader)
+}
+
+// emptyReader is a source with no data, as a gzip.Reader reads a header from its source when it is reset.
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+func (emptyReader) ReadByte() (byte, error) { return 0, io.EOF }
+
+// readAll reads reader into a pooled buffer and returns a copy of what was read.
+func readAll(reader io.Reader) ([]byte, error) {
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+
+	_, err := buffer.ReadFrom(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "read all failed")
+	}
+
+	return copyBuffer(buffer), nil
+}
+
 func gzipDecompression(data []byte) ([]byte, error) {
-	buffer := bytes.NewBuffer(data)
+	source := bytes.NewReader(data)
 
-	reader, err := gzip.NewReader(buffer)
+	var err error
+	reader, ok := gzipReaderPool.Get().(*gzip.Reader)
+	if ok {
+		err = reader.Reset(source)
+	} else {
+		reader, err = gzip.NewReader(source)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create new gzip reader")
 	}
+	defer putGzipReader(reader)
 
-	decompressedDat, err := ioutil.ReadAll(reader)
+	decompressedDat, err := readAll(reader)
 	if err != nil {
-		return nil, errors.Wrap(err, "read all failed")
+		return nil, err
 	}
 
 	err = reader.Close()
@@ -82,14 +139,27 @@ func gzipDecompression(data []byte) ([]byte, error) {
 }
 
 func snappyDecompression(data []byte) ([]byte, error) {
-	buffer := bytes.NewBuffer(data)
+	reader := snappyReaderPool.Get().(*snappy.Reader)
+	defer putSnappyReader(reader)
+	reader.Reset(bytes.NewReader(data))
 
-	reader := snappy.NewReader(buffer)
+	return readAll(reader)
+}
 
-	decompressedDat, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, errors.Wrap(err, "read all failed")
+func lz4Decompression(data []byte) ([]byte, error) {
+	reader := lz4ReaderPool.Get().(*lz4.Reader)
+	defer putLZ4Reader(reader)
+	reader.Reset(bytes.NewReader(data))
+
+	return readAll(reader)
+}
+
+func brotliDecompression(data []byte) ([]byte, error) {
+	reader := brotliReaderPool.Get().(*brotli.Reader)
+	defer putBrotliReader(reader)
+	if err := reader.Reset(bytes.NewReader(data)); err != nil {
+		return nil, errors.Wrap(err, "cannot reset brotli reader")
 	}
 
-	return decompressedDat, nil
+	return readAll(reader)
 }
@@ -18,7 +18,9 @@ package heimdall
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bytedance/heimdall/constants"
 	"github.com/stretchr/testify/assert"
@@ -61,3 +63,124 @@ func TestStructNoCompression(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, populatedStruct, newStruct)
 }
+
+func TestStructZstd(t *testing.T) {
+	ctx := context.Background()
+	populatedStruct := &testStruct{Foo: "Bar", World: 42}
+	compressedData, _ := CompressStruct(ctx, populatedStruct, constants.ZstdCompressionType)
+
+	newStruct := &testStruct{}
+	err := DecompressStruct(ctx, compressedData, newStruct, constants.ZstdCompressionType)
+	assert.Nil(t, err)
+	assert.Equal(t, populatedStruct, newStruct)
+}
+
+func TestStructLZ4(t *testing.T) {
+	ctx := context.Background()
+	populatedStruct := &testStruct{Foo: "Bar", World: 42}
+	compressedData, _ := CompressStruct(ctx, populatedStruct, constants.LZ4CompressionType)
+
+	newStruct := &testStruct{}
+	err := DecompressStruct(ctx, compressedData, newStruct, constants.LZ4CompressionType)
+	assert.Nil(t, err)
+	assert.Equal(t, populatedStruct, newStruct)
+}
+
+func TestStructBrotli(t *testing.T) {
+	ctx := context.Background()
+	populatedStruct := &testStruct{Foo: "Bar", World: 42}
+	compressedData, _ := CompressStruct(ctx, populatedStruct, constants.BrotliCompressionType)
+
+	newStruct := &testStruct{}
+	err := DecompressStruct(ctx, compressedData, newStruct, constants.BrotliCompressionType)
+	assert.Nil(t, err)
+	assert.Equal(t, populatedStruct, newStruct)
+}
+
+type reverseCompressor struct{}
+
+func (reverseCompressor) Compress(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[len(data)-1-i] = b
+	}
+	return out, nil
+}
+
+func (c reverseCompressor) Decompress(data []byte) ([]byte, error) { return c.Compress(data) }
+func (reverseCompressor) ID() constants.CompressionLibraryType {
+	return constants.CustomCompressionTypeStart
+}
+
+func TestRegisterCompressor(t *testing.T) {
+	ctx := context.Background()
+	populatedStruct := &testStruct{Foo: "Bar", World: 42}
+
+	_, err := CompressStruct(ctx, populatedStruct, constants.CustomCompressionTypeStart)
+	assert.Error(t, err)
+
+	assert.NoError(t, RegisterCompressor(reverseCompressor{}))
+	t.Cleanup(func() { compressors.Delete(constants.CustomCompressionTypeStart) })
+	assert.Error(t, RegisterCompressor(reverseCompressor{}))
+	assert.Error(t, RegisterCompressor(nil))
+
+	compressedData, err := CompressStruct(ctx, populatedStruct, constants.CustomCompressionTypeStart)
+	assert.NoError(t, err)
+	assert.Equal(t, byte('}'), compressedData[0])
+
+	newStruct := &testStruct{}
+	err = DecompressStruct(ctx, compressedData, newStruct, constants.CustomCompressionTypeStart)
+	assert.Nil(t, err)
+	assert.Equal(t, populatedStruct, newStruct)
+}
+
+var benchmarkCompressionLibraries = []constants.CompressionLibraryType{
+	constants.NoCompressionType,
+	constants.GzipCompressionType,
+	constants.SnappyCompressionType,
+	constants.ZstdCompressionType,
+	constants.LZ4CompressionType,
+	constants.BrotliCompressionType,
+}
+
+func benchmarkCacheValue() *CacheValue {
+	return &CacheValue{
+		UpdatedTS: time.Now().Unix(),
+		SoftTTL:   time.Minute,
+		Data:      strings.Repeat(`{"user_id":"1000","display_name":"user","country":"SG","verified":true},`, 64),
+	}
+}
+
+func BenchmarkCompressStruct(b *testing.B) {
+	ctx := context.Background()
+	cacheVal := benchmarkCacheValue()
+	for _, library := range benchmarkCompressionLibraries {
+		b.Run(library.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := CompressStruct(ctx, cacheVal, library); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecompressStruct(b *testing.B) {
+	ctx := context.Background()
+	cacheVal := benchmarkCacheValue()
+	for _, library := range benchmarkCompressionLibraries {
+		data, err := CompressStruct(ctx, cacheVal, library)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(library.String(), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := DecompressStruct(ctx, data, &CacheValue{}, library); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
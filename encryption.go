@@ -0,0 +1,158 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const maxKeyIDLength = 255
+
+var (
+	keyProvider       KeyProvider
+	rejectUnencrypted bool
+	// aeads caches the AES-GCM ciphers by key id, as key ids always map to the same key.
+	aeads sync.Map // key id -> cipher.AEAD
+
+	// ErrDecryption is returned when an encrypted entry cannot be decrypted. Such entries are treated as misses.
+	ErrDecryption = errors.New("unable to decrypt cache entry")
+)
+
+// KeyProvider provides the AES keys that entries are encrypted with. Keys are 16, 24 or 32 bytes long, for AES-128,
+// AES-192 or AES-256. A key id must always map to the same key. To rotate keys, make CurrentKey return a new key while
+// Key still returns the old ones, until the entries written with them have expired.
+// Implementations must be safe for concurrent use, and should cache keys fetched from a key management service.
+type KeyProvider interface {
+	// CurrentKey returns the key new entries are encrypted with, and its id. The id is stored in every entry and can
+	// be at most 255 bytes long.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given id, to decrypt entries written with it.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// InjectKeyProvider sets the key provider. Entries are encrypted with AES-GCM after compression if it is set. With
+// rejectUnencrypted, entries that are not encrypted are treated as misses; this is always the case unless entries are
+// signed with an HMAC secret, so that entries planted in plaintext by anyone with write access to the cache are never
+// served.
+//
+// The ciphers of the keys are cached by key id until the next call to InjectKeyProvider: Key is only called the first
+// time an entry written with a key id is read, and the key returned by CurrentKey is only used the first time its id is
+// seen. A key id must therefore never be reused for a different key, and a key revoked in the key management service
+// can still decrypt entries until the key provider is injected again or the process restarts.
+func InjectKeyProvider(kp KeyProvider, rejectUnencryptedEntries bool) {
+	keyProvider = kp
+	rejectUnencrypted = rejectUnencryptedEntries
+	aeads.Range(func(id, _ any) bool {
+		aeads.Delete(id)
+		return true
+	})
+}
+
+// rejectsUnencrypted reports whether entries that are not encrypted are treated as misses.
+func rejectsUnencrypted() bool {
+	return keyProvider != nil && (rejectUnencrypted || integrityFlag() != flagHMAC)
+}
+
+func cachedAEAD(id string) (cipher.AEAD, bool) {
+	aead, ok := aeads.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return aead.(cipher.AEAD), true
+}
+
+func getAEAD(id string, key []byte) (cipher.AEAD, error) {
+	if aead, ok := cachedAEAD(id); ok {
+		return aead, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid key %s", id)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid key %s", id)
+	}
+	aeads.Store(id, aead)
+	return aead, nil
+}
+
+// encrypt seals payload with the current key. The envelope header is authenticated as additional data, so that the
+// codec of an entry cannot be tampered with. The sealed payload is:
+//
+//	key id length (1 byte) | key id | nonce | ciphertext
+func encrypt(ctx context.Context, header, payload []byte) ([]byte, error) {
+	id, key, err := keyProvider.CurrentKey(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get the current encryption key")
+	}
+	if len(id) > maxKeyIDLength {
+		return nil, errors.Errorf("key id %s is longer than %d bytes", id, maxKeyIDLength)
+	}
+	aead, err := getAEAD(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 0, 1+len(id)+aead.NonceSize()+len(payload)+aead.Overhead())
+	sealed = append(sealed, byte(len(id)))
+	sealed = append(sealed, id...)
+	nonce := sealed[len(sealed) : len(sealed)+aead.NonceSize()]
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "unable to generate nonce")
+	}
+	sealed = sealed[:len(sealed)+aead.NonceSize()]
+	return aead.Seal(sealed, nonce, payload, header), nil
+}
+
+// decrypt opens a payload sealed by encrypt. Every failure wraps ErrDecryption.
+func decrypt(ctx context.Context, header, sealed []byte) ([]byte, error) {
+	if keyProvider == nil {
+		return nil, errors.Wrap(ErrDecryption, "entry is encrypted but there is no key provider")
+	}
+	if len(sealed) < 1 || len(sealed) < 1+int(sealed[0]) {
+		return nil, errors.Wrap(ErrDecryption, "entry is truncated")
+	}
+	id, sealed := string(sealed[1:1+int(sealed[0])]), sealed[1+int(sealed[0]):]
+
+	aead, ok := cachedAEAD(id)
+	if !ok {
+		key, err := keyProvider.Key(ctx, id)
+		if err != nil {
+			return nil, errors.Wrapf(ErrDecryption, "unable to get key %s: %v", id, err)
+		}
+		if aead, err = getAEAD(id, key); err != nil {
+			return nil, errors.Wrap(ErrDecryption, err.Error())
+		}
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.Wrap(ErrDecryption, "entry is truncated")
+	}
+
+	payload, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], header)
+	if err != nil {
+		return nil, errors.Wrapf(ErrDecryption, "unable to open entry with key %s: %v", id, err)
+	}
+	return payload, nil
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+type staticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+	lookups int
+}
+
+func (p *staticKeyProvider) CurrentKey(ctx context.Context) (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *staticKeyProvider) Key(ctx context.Context, id string) ([]byte, error) {
+	p.lookups++
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.Errorf("unknown key %s", id)
+	}
+	return key, nil
+}
+
+func TestEncryption(t *testing.T) {
+	data := map[string]any{}
+	mockCache(data)
+	kp := &staticKeyProvider{
+		current: "encryption-test-1",
+		keys: map[string][]byte{
+			"encryption-test-1": bytes.Repeat([]byte{1}, 32),
+			"encryption-test-2": bytes.Repeat([]byte{2}, 16),
+		},
+	}
+	InjectCompressionLibrary(constants.SnappyCompressionType)
+	InjectKeyProvider(kp, false)
+	t.Cleanup(func() {
+		InjectCompressionLibrary(constants.NoCompressionType)
+		InjectKeyProvider(nil, false)
+	})
+
+	ctx := context.Background()
+	cacheVal := &CacheValue{UpdatedTS: time.Now().Unix(), Data: "sensitive"}
+	assert.NoError(t, setCacheValue(ctx, "old", cacheVal, time.Minute, "rpcCallName"))
+	assert.NotContains(t, string(data["old"].([]byte)), "sensitive")
+
+	// rotation: new entries are written with the new key, old entries are still read with the old key.
+	kp.current = "encryption-test-2"
+	assert.NoError(t, setCacheValue(ctx, "new", cacheVal, time.Minute, "rpcCallName"))
+	assert.Contains(t, string(data["new"].([]byte)), "encryption-test-2")
+	// the ciphers are cached, every key is only looked up once
+	InjectKeyProvider(kp, false)
+	for _, key := range []string{"old", "new", "old", "new"} {
+		got, err := fetchFromCache(ctx, key, "rpcCallName")
+		assert.NoError(t, err)
+		assert.Equal(t, cacheVal, got)
+	}
+	assert.Equal(t, 2, kp.lookups)
+
+	tests := []struct {
+		name   string
+		mutate func()
+	}{
+		{
+			name: "retired key",
+			mutate: func() {
+				delete(kp.keys, "encryption-test-1")
+				InjectKeyProvider(kp, false)
+			},
+		}, {
+			name: "tampered entry",
+			mutate: func() {
+				tampered := append([]byte(nil), data["new"].([]byte)...)
+				tampered[len(tampered)-1] ^= 1
+				data["old"] = tampered
+			},
+		}, {
+			name: "tampered codec",
+			mutate: func() {
+				tampered := append([]byte(nil), data["new"].([]byte)...)
+				tampered[4] ^= 1
+				data["old"] = tampered
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.mutate()
+			_, err := fetchFromCache(ctx, "old", "rpcCallName")
+			assert.True(t, errors.Is(err, ErrDecryption))
+		})
+	}
+}
+
+func TestEncryptionRejectsUnencryptedEntries(t *testing.T) {
+	kp := &staticKeyProvider{current: "1", keys: map[string][]byte{"1": make([]byte, 32)}}
+	tests := []struct {
+		name      string
+		integrity *IntegrityConfig
+		reject    bool
+		rejected  bool
+	}{
+		{
+			name:     "no integrity",
+			rejected: true,
+		}, {
+			name:      "checksum",
+			integrity: &IntegrityConfig{},
+			rejected:  true,
+		}, {
+			name:      "hmac",
+			integrity: &IntegrityConfig{HMACSecret: []byte("secret")},
+			rejected:  false,
+		}, {
+			name:      "hmac rejecting unencrypted entries",
+			integrity: &IntegrityConfig{HMACSecret: []byte("secret")},
+			reject:    true,
+			rejected:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]any{}
+			mockCache(data)
+			InjectIntegrityConfig(tt.integrity)
+			t.Cleanup(func() {
+				InjectIntegrityConfig(nil)
+				InjectKeyProvider(nil, false)
+			})
+
+			// the entry is written before encryption is enabled
+			ctx := context.Background()
+			cacheVal := &CacheValue{UpdatedTS: time.Now().Unix(), Data: "plaintext"}
+			assert.NoError(t, setCacheValue(ctx, "key", cacheVal, time.Minute, "rpcCallName"))
+
+			InjectKeyProvider(kp, tt.reject)
+			_, err := fetchFromCache(ctx, "key", "rpcCallName")
+			assert.Equal(t, tt.rejected, errors.Is(err, ErrDecryption))
+			assert.Equal(t, tt.rejected, err != nil)
+		})
+	}
+}
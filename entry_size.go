@@ -0,0 +1,144 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	"github.com/bytedance/heimdall/cache"
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+// ErrEntryTooLarge is reported through the ErrorHook when an entry is not cached as it is larger than the maximum
+// entry size. The previous entry of the key is deleted, so that it is not served stale.
+var ErrEntryTooLarge = errors.New("cache entry is larger than the maximum entry size")
+
+var (
+	entrySizeConfig        *EntrySizeConfig
+	methodEntrySizeConfigs map[string]*EntrySizeConfig
+)
+
+// EntrySizeConfig limits the size of the entries written to the cache, as very large values hurt the latency of the
+// cache for every caller.
+type EntrySizeConfig struct {
+	// MaxSize is the maximum size in bytes of an entry, after compression. 0 means no limit.
+	MaxSize int `json:"max_size,omitempty" yaml:"max_size,omitempty" xml:"max_size,omitempty"`
+	// Policy is the behaviour for entries larger than MaxSize. Defaults to SkipOversizePolicy.
+	Policy constants.OversizePolicyType `json:"policy,omitempty" yaml:"policy,omitempty" xml:"policy,omitempty"`
+	// ChunkSize is the size in bytes of the chunks under ChunkOversizePolicy. Defaults to MaxSize.
+	ChunkSize int `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty" xml:"chunk_size,omitempty"`
+}
+
+func (c *EntrySizeConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxSize < 0 || c.ChunkSize < 0 {
+		return errors.Errorf("entry size config cannot have negative values")
+	}
+
+	if c.Policy < constants.SkipOversizePolicy || c.Policy > constants.ChunkOversizePolicy {
+		return errors.Errorf("invalid oversize policy specified.")
+	}
+	return nil
+}
+
+// InjectEntrySizeConfig sets the default entry size limit and the per-method overrides, keyed by rpc call name.
+func InjectEntrySizeConfig(cfg *EntrySizeConfig, methodCfgs map[string]*EntrySizeConfig) {
+	entrySizeConfig = cfg
+	methodEntrySizeConfigs = methodCfgs
+}
+
+// entrySizeOption is a grpc.CallOption that overrides the entry size limit of a single call. gRPC ignores it.
+type entrySizeOption struct {
+	grpc.EmptyCallOption
+	cfg *EntrySizeConfig
+}
+
+// WithEntrySizeConfig overrides the entry size limit of the method for the call. A nil config removes the limit.
+func WithEntrySizeConfig(cfg *EntrySizeConfig) grpc.CallOption {
+	return entrySizeOption{cfg: cfg}
+}
+
+type entrySizeKey struct{}
+
+// withCallEntrySizeConfig carries the entry size limit set by the options of the call in ctx, as the call is written
+// to the cache in the background.
+func withCallEntrySizeConfig(ctx context.Context, opts []grpc.CallOption) (context.Context, error) {
+	for i := len(opts) - 1; i >= 0; i-- {
+		if o, ok := opts[i].(entrySizeOption); ok {
+			if err := o.cfg.validate(); err != nil {
+				return nil, err
+			}
+			return context.WithValue(ctx, entrySizeKey{}, o), nil
+		}
+	}
+	return ctx, nil
+}
+
+// copyCallEntrySizeConfig carries the entry size limit of the call carried by src over to dst.
+func copyCallEntrySizeConfig(dst, src context.Context) context.Context {
+	if o, ok := src.Value(entrySizeKey{}).(entrySizeOption); ok {
+		return context.WithValue(dst, entrySizeKey{}, o)
+	}
+	return dst
+}
+
+// getEntrySizeConfig returns the entry size limit of the call carried by ctx, or else of the method.
+func getEntrySizeConfig(ctx context.Context, rpcCallName string) *EntrySizeConfig {
+	if o, ok := ctx.Value(entrySizeKey{}).(entrySizeOption); ok {
+		return o.cfg
+	}
+	if cfg, ok := methodEntrySizeConfigs[rpcCallName]; ok {
+		return cfg
+	}
+	return entrySizeConfig
+}
+
+// setCacheValue compresses cacheVal and writes it under key, applying the entry size limit of the call or method.
+func setCacheValue(ctx context.Context, key string, cacheVal *CacheValue, ttl time.Duration, rpcCallName string) error {
+	compressedData, err := encodeCacheValue(ctx, key, cacheVal, rpcCallName)
+	if err != nil {
+		return err
+	}
+
+	cfg := getEntrySizeConfig(ctx, rpcCallName)
+	if cfg == nil || cfg.MaxSize == 0 || len(compressedData) <= cfg.MaxSize {
+		return cacheProvider.Set(ctx, key, compressedData, ttl)
+	}
+
+	if !isSkipMetrics() {
+		metricsProvider.EmitOversizeEntryMetric(ctx, rpcCallName, len(compressedData))
+	}
+	if cfg.Policy == constants.ChunkOversizePolicy {
+		chunkSize := helpers.TernaryOp(cfg.ChunkSize > 0, cfg.ChunkSize, cfg.MaxSize)
+		return cacheProvider.SetChunked(ctx, key, compressedData, chunkSize, ttl)
+	}
+
+	err = errors.Wrapf(ErrEntryTooLarge, "entry of %d bytes exceeds %d bytes", len(compressedData), cfg.MaxSize)
+	// the previous entry would otherwise be served stale until its hard TTL, and refreshed in vain after its soft TTL.
+	if deleteErr := cacheProvider.Delete(ctx, key); deleteErr != nil && !errors.Is(deleteErr, cache.ErrDeleteNotSupported) {
+		return errors.Wrapf(err, "unable to delete the previous entry: %v", deleteErr)
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestSetCacheValueEntrySize(t *testing.T) {
+	cacheVal := &CacheValue{UpdatedTS: time.Now().Unix(), Data: strings.Repeat("x", 100)}
+	tests := []struct {
+		name     string
+		config   *EntrySizeConfig
+		stored   bool
+		chunks   int
+		tooLarge bool
+	}{
+		{
+			name:   "no limit",
+			config: nil,
+			stored: true,
+		}, {
+			name:   "under the limit",
+			config: &EntrySizeConfig{MaxSize: 1000},
+			stored: true,
+		}, {
+			name:     "oversize skipped",
+			config:   &EntrySizeConfig{MaxSize: 50},
+			stored:   false,
+			tooLarge: true,
+		}, {
+			name:   "oversize chunked",
+			config: &EntrySizeConfig{MaxSize: 50, Policy: constants.ChunkOversizePolicy, ChunkSize: 40},
+			stored: true,
+			chunks: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the previous entry is not served once the new one is too large
+			data := map[string]any{"key": testMakeCacheValue(testResp, false)}
+			mockCache(data)
+			InjectEntrySizeConfig(nil, map[string]*EntrySizeConfig{"rpcCallName": tt.config})
+			t.Cleanup(func() { InjectEntrySizeConfig(nil, nil) })
+
+			ctx := context.Background()
+			err := setCacheValue(ctx, "key", cacheVal, time.Minute, "rpcCallName")
+			assert.Equal(t, tt.tooLarge, err != nil)
+			assert.Equal(t, tt.tooLarge, errors.Is(err, ErrEntryTooLarge))
+
+			got, err := fetchFromCache(ctx, "key", "rpcCallName")
+			assert.Equal(t, tt.stored, err == nil)
+			if tt.stored {
+				assert.Equal(t, cacheVal, got)
+			}
+			if tt.chunks > 0 {
+				assert.Len(t, data, tt.chunks+1)
+			}
+		})
+	}
+}
+
+func TestEntrySizeCallOption(t *testing.T) {
+	waitForBackgroundTasks()
+	data := map[string]any{}
+	mockCache(data)
+	var reported error
+	InjectErrorHook(func(ctx context.Context, rpcCallName string, err error) { reported = err })
+	t.Cleanup(func() { InjectErrorHook(nil) })
+
+	c := &TestRPCClient{}
+	_, err := GRPCCall(c.TestRPCCall, context.Background(), testReq, WithEntrySizeConfig(&EntrySizeConfig{MaxSize: 1}))
+	assert.NoError(t, err)
+	waitForBackgroundTasks()
+	assert.Empty(t, data)
+	assert.ErrorIs(t, reported, ErrEntryTooLarge)
+
+	_, err = GRPCCall(c.TestRPCCall, context.Background(), testReq, WithEntrySizeConfig(&EntrySizeConfig{MaxSize: -1}))
+	assert.Error(t, err)
+}
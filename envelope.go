@@ -0,0 +1,195 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+// An envelope wraps an entry with a header describing how it was encoded, so that entries written with different
+// settings can be read back. Its layout is:
+//
+//	magic (2 bytes) | version (1 byte) | flags (1 byte) | codec id (1 byte) | payload | checksum or signature
+//
+// Entries without the magic are legacy entries, compressed with the configured CompressionLibrary.
+const (
+	envelopeVersion    = 1
+	envelopeHeaderSize = 5
+
+	maxEnvelopeCodec = 255
+
+	// flagEncrypted is set if the payload is encrypted after compression.
+	flagEncrypted byte = 1 << 0
+	// flagChecksum is set if the payload is followed by a CRC32C checksum of the header and payload.
+	flagChecksum byte = 1 << 1
+	// flagHMAC is set if the payload is followed by an HMAC-SHA256 signature of the header and payload.
+	flagHMAC byte = 1 << 2
+
+	knownEnvelopeFlags = flagEncrypted | flagChecksum | flagHMAC
+)
+
+var envelopeMagic = [2]byte{'H', 'D'}
+
+type envelope struct {
+	flags   byte
+	codec   constants.CompressionLibraryType
+	payload []byte
+}
+
+func (e *envelope) header() []byte {
+	return []byte{envelopeMagic[0], envelopeMagic[1], envelopeVersion, e.flags, byte(e.codec)}
+}
+
+func (e *envelope) marshal() []byte {
+	b := make([]byte, 0, envelopeHeaderSize+len(e.payload))
+	b = append(b, e.header()...)
+	return append(b, e.payload...)
+}
+
+// unmarshalEnvelope returns false if data is a legacy entry.
+func unmarshalEnvelope(data []byte) (*envelope, bool, error) {
+	if len(data) < envelopeHeaderSize || data[0] != envelopeMagic[0] || data[1] != envelopeMagic[1] {
+		return nil, false, nil
+	}
+	if data[2] != envelopeVersion {
+		return nil, true, errors.Errorf("unsupported entry version %d", data[2])
+	}
+	if data[3]&^knownEnvelopeFlags != 0 {
+		return nil, true, errors.Errorf("unsupported entry flags %b", data[3])
+	}
+	return &envelope{flags: data[3], codec: constants.CompressionLibraryType(data[4]), payload: data[envelopeHeaderSize:]}, true, nil
+}
+
+// useEnvelope reports whether entries are written in an envelope. Entries are written in the legacy format unless a
+// feature needs the envelope, so that enabling Heimdall's defaults does not change the format of existing entries.
+func useEnvelope() bool {
+	return compressionPolicy != nil || keyProvider != nil || integrityConfig != nil
+}
+
+// encodeCacheValue marshals, compresses, encrypts and checksums cacheVal for the cache, to be stored under key.
+func encodeCacheValue(ctx context.Context, key string, cacheVal *CacheValue, rpcCallName string) ([]byte, error) {
+	if !useEnvelope() {
+		return compressStruct(ctx, cacheVal, compressionLibrary, rpcCallName)
+	}
+
+	b, err := json.Marshal(cacheVal)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal struct")
+	}
+
+	e := &envelope{codec: chooseCompressionLibrary(len(b)), payload: b}
+	if e.codec != constants.NoCompressionType {
+		compressed, err := compressBytes(ctx, b, e.codec, rpcCallName)
+		if err != nil {
+			return nil, err
+		}
+		// payloads that do not shrink are stored raw, so that reads do not pay for decompression.
+		if len(compressed) < len(b) {
+			e.payload = compressed
+		} else {
+			e.codec = constants.NoCompressionType
+		}
+	}
+
+	// the header is final before encryption, as it is authenticated with the payload.
+	e.flags |= integrityFlag()
+	if keyProvider != nil {
+		e.flags |= flagEncrypted
+		if e.payload, err = encrypt(ctx, e.header(), e.payload); err != nil {
+			return nil, err
+		}
+	}
+
+	data := e.marshal()
+	if flag := integrityFlag(); flag != 0 {
+		data = append(data, integritySum(flag, key, data)...)
+	}
+	return data, nil
+}
+
+// decodeCacheValue verifies, decrypts, decompresses and unmarshals an entry read from the cache under key into cacheVal.
+// Any error makes the read a miss.
+func decodeCacheValue(ctx context.Context, key string, data []byte, cacheVal *CacheValue, rpcCallName string) error {
+	e, ok, err := unmarshalEnvelope(data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if integrityFlag() == flagHMAC {
+			return errors.Wrap(ErrIntegrity, "entry is not signed")
+		}
+		if rejectsUnencrypted() {
+			return unencryptedEntryError(ctx, rpcCallName)
+		}
+		return decompressStruct(ctx, data, cacheVal, compressionLibrary, rpcCallName)
+	}
+
+	if err = verifyIntegrity(e, key); err != nil {
+		return err
+	}
+	payload := e.payload
+	if e.flags&flagEncrypted != 0 {
+		if payload, err = decrypt(ctx, e.header(), payload); err != nil {
+			if !isSkipMetrics() {
+				metricsProvider.IncreaseDecryptionFailureMetric(ctx, rpcCallName)
+			}
+			return err
+		}
+	} else if rejectsUnencrypted() {
+		return unencryptedEntryError(ctx, rpcCallName)
+	}
+	return decompressStruct(ctx, payload, cacheVal, e.codec, rpcCallName)
+}
+
+// unencryptedEntryError counts an entry rejected for not being encrypted as a decryption failure.
+func unencryptedEntryError(ctx context.Context, rpcCallName string) error {
+	if !isSkipMetrics() {
+		metricsProvider.IncreaseDecryptionFailureMetric(ctx, rpcCallName)
+	}
+	return errors.Wrap(ErrDecryption, "entry is not encrypted")
+}
+
+// compressBytes compresses data with the compressor registered for compressionLibrary and emits compression metrics.
+func compressBytes(ctx context.Context, data []byte, compressionLibrary constants.CompressionLibraryType, rpcCallName string) ([]byte, error) {
+	compressor, err := getCompressor(compressionLibrary)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	var compressed []byte
+	if c, ok := compressor.(methodCompressor); ok {
+		compressed, err = c.compressMethod(data, rpcCallName)
+	} else {
+		compressed, err = compressor.Compress(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSkipMetrics() && len(data) > 0 && compressionLibrary != constants.NoCompressionType {
+		ratio := float64(len(compressed)) / float64(len(data))
+		metricsProvider.EmitCompressionMetric(ctx, rpcCallName, compressionLibrary.String(), ratio, time.Since(start))
+	}
+	return compressed, nil
+}
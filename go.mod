@@ -3,11 +3,17 @@ module github.com/bytedance/heimdall
 go 1.18
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/bytedance/sonic v1.8.4
 	github.com/go-redis/redis/v9 v9.0.0-rc.2
 	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.16.7
+	github.com/pierrec/lz4/v4 v4.1.18
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.8.1
+	github.com/zeebo/blake3 v0.2.3
+	github.com/zeebo/xxh3 v1.0.2
+	golang.org/x/time v0.3.0
 	google.golang.org/grpc/examples v0.0.0-20230308214047-ad4057fcc57e
 	google.golang.org/protobuf v1.29.0
 )
@@ -27,7 +27,7 @@ import (
 
 // GRPCCall wraps a grpc call method with Heimdall. It uses the global default set hard and soft TTLs.
 func GRPCCall[request, response any](grpcFunc func(ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error), ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error) {
-	return GRPCCallWithTTL(grpcFunc, ctx, req, defaultSoftTTL, defaultHardTTL)
+	return GRPCCallWithTTL(grpcFunc, ctx, req, defaultSoftTTL, defaultHardTTL, opts...)
 }
 
 // GRPCCallWithTTL wraps a grpc call method with Heimdall. It uses user defined hard and soft TTLs.
@@ -36,19 +36,35 @@ func GRPCCallWithTTL[request, response any](grpcFunc func(ctx context.Context, r
 		return nil, errors.Errorf("grpcFunc is nil")
 	}
 
+	if isSkipCache() {
+		return grpcFunc(ctx, req, opts...)
+	}
+
 	rpcCallName := helpers.GetFunctionName(grpcFunc)
 
-	cacheKey, err := helpers.GenerateCacheKey(req, rpcCallName, softTTL, hardTTL, version)
+	keyFor, err := newKeyGenerator(ctx, req, rpcCallName, softTTL, hardTTL, opts)
+	if errors.Is(err, errMissingKeyDimension) {
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseMissingKeyDimensionMetric(ctx, rpcCallName)
+		}
+		return grpcFunc(ctx, req, opts...)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if ctx, err = withCallEntrySizeConfig(ctx, opts); err != nil {
+		return nil, err
+	}
+	ctx = withCallDimensions(ctx, rpcCallName, opts)
+	recordCall(ctx, req, rpcCallName, softTTL, hardTTL)
 
-	return getData(ctx, wrapGRPCCallFunc(grpcFunc, ctx, req, opts...), rpcCallName, cacheKey, softTTL,
+	return getData(ctx, wrapGRPCCallFunc(grpcFunc, req, opts...), rpcCallName, keyFor(version),
+		previousVersionKeys(keyFor), softTTL,
 		hardTTL, func() bool { return true }, func(resp *response) bool { return true })
 }
 
-func wrapGRPCCallFunc[request, response any](grpcFunc func(ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error), ctx context.Context, req *request, opts ...grpc.CallOption) func() (*response, error) {
-	return func() (*response, error) {
+func wrapGRPCCallFunc[request, response any](grpcFunc func(ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error), req *request, opts ...grpc.CallOption) func(ctx context.Context) (*response, error) {
+	return func(ctx context.Context) (*response, error) {
 		return grpcFunc(ctx, req, opts...)
 	}
 }
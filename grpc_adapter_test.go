@@ -19,6 +19,7 @@ package heimdall
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -168,10 +169,13 @@ func mockCache(data map[string]any) {
 }
 
 type mockedCache struct {
+	mu         sync.Mutex
 	mockedData map[string]any
 }
 
 func (m *mockedCache) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	mockedVal, ok := m.mockedData[key]
 	if !ok {
 		return nil, errors.Errorf("cannot find key in mocked cache: %s, cache: %s", key, helpers.DumpJSON(m.mockedData))
@@ -186,6 +190,15 @@ func (m *mockedCache) Get(_ context.Context, key string) ([]byte, error) {
 }
 
 func (m *mockedCache) Set(_ context.Context, key string, val any, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.mockedData[key] = val
 	return nil
 }
+
+func (m *mockedCache) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.mockedData, key)
+	return nil
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	latencySampleSize      = 256
+	minLatencySamples      = 20
+	latencyRecomputeEvery  = 16
+	defaultHedgePercentile = 0.95
+	defaultHedgeDelay      = 100 * time.Millisecond
+	defaultHedgeMinDelay   = time.Millisecond
+)
+
+var (
+	hedgeConfig        *HedgeConfig
+	methodHedgeConfigs map[string]*HedgeConfig
+	latencyTrackers    sync.Map // rpc call name -> *latencyTracker
+)
+
+// HedgeConfig enables hedged calls on cache misses. If the downstream call has not returned after the configured
+// percentile of its recent latencies, a second identical call is issued and the first successful response wins.
+// Only enable hedging for idempotent calls.
+type HedgeConfig struct {
+	// Percentile of the recent latencies of the method after which the hedge call is issued, e.g. 0.95. Defaults to 0.95.
+	Percentile float64 `json:"percentile,omitempty" yaml:"percentile,omitempty" xml:"percentile,omitempty"`
+	// Delay is the hedge delay used until enough latencies of the method have been observed. Defaults to 100ms.
+	Delay time.Duration `json:"delay,omitempty" yaml:"delay,omitempty" xml:"delay,omitempty"`
+	// MinDelay is the lower bound of the hedge delay, so that very fast methods are not hedged on every call.
+	// Defaults to 1ms.
+	MinDelay time.Duration `json:"min_delay,omitempty" yaml:"min_delay,omitempty" xml:"min_delay,omitempty"`
+}
+
+func (c *HedgeConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Percentile < 0 || c.Percentile >= 1 {
+		return errors.Errorf("hedge percentile must be in the range [0, 1)")
+	}
+
+	if c.Delay < 0 || c.MinDelay < 0 {
+		return errors.Errorf("hedge delays cannot be negative")
+	}
+	return nil
+}
+
+// latencyTracker keeps a window of the most recent successful call latencies of a method.
+type latencyTracker struct {
+	mu      sync.Mutex
+	samples [latencySampleSize]time.Duration
+	count   int
+	next    int
+	stale   int
+	cached  time.Duration
+}
+
+func (t *latencyTracker) record(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples[t.next] = latency
+	t.next = (t.next + 1) % latencySampleSize
+	if t.count < latencySampleSize {
+		t.count++
+	}
+	t.stale++
+}
+
+// delay returns the configured percentile of the recorded latencies. It is recomputed every few samples only,
+// as it requires sorting the window.
+func (t *latencyTracker) delay(cfg *HedgeConfig) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	minDelay := cfg.MinDelay
+	if minDelay == 0 {
+		minDelay = defaultHedgeMinDelay
+	}
+
+	if t.count < minLatencySamples {
+		if cfg.Delay > 0 {
+			return cfg.Delay
+		}
+		return defaultHedgeDelay
+	}
+
+	if t.cached == 0 || t.stale >= latencyRecomputeEvery {
+		percentile := cfg.Percentile
+		if percentile == 0 {
+			percentile = defaultHedgePercentile
+		}
+		sorted := make([]time.Duration, t.count)
+		copy(sorted, t.samples[:t.count])
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		t.cached = sorted[int(percentile*float64(t.count-1))]
+		t.stale = 0
+	}
+
+	if t.cached < minDelay {
+		return minDelay
+	}
+	return t.cached
+}
+
+// InjectHedgeConfig sets the default hedge config and the per-method overrides, keyed by rpc call name.
+func InjectHedgeConfig(cfg *HedgeConfig, methodCfgs map[string]*HedgeConfig) {
+	hedgeConfig = cfg
+	methodHedgeConfigs = methodCfgs
+}
+
+func getHedgeConfig(rpcCallName string) *HedgeConfig {
+	if cfg, ok := methodHedgeConfigs[rpcCallName]; ok {
+		return cfg
+	}
+	return hedgeConfig
+}
+
+func getLatencyTracker(rpcCallName string) *latencyTracker {
+	t, _ := latencyTrackers.LoadOrStore(rpcCallName, &latencyTracker{})
+	return t.(*latencyTracker)
+}
+
+type hedgeResult[response any] struct {
+	resp  *response
+	err   error
+	hedge bool
+}
+
+// hedgedCall calls rpcCall and, if hedging is enabled for the method and the call has not returned within the hedge
+// delay, issues a second call. The first successful response is returned and the other call is cancelled.
+func hedgedCall[response any](ctx context.Context, rpcCallName string, rpcCall func(ctx context.Context) (*response, error)) (*response, error) {
+	cfg := getHedgeConfig(rpcCallName)
+	if cfg == nil {
+		return rpcCall(ctx)
+	}
+
+	tracker := getLatencyTracker(rpcCallName)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult[response], 2)
+	call := func(hedge bool, release func()) {
+		defer release()
+		start := time.Now()
+		resp, err := rpcCall(ctx)
+		if err == nil {
+			tracker.record(time.Since(start))
+		}
+		results <- hedgeResult[response]{resp: resp, err: err, hedge: hedge}
+	}
+
+	go call(false, func() {})
+	timer := time.NewTimer(tracker.delay(cfg))
+	defer timer.Stop()
+
+	var (
+		inflight = 1
+		firstErr error
+	)
+	for {
+		select {
+		case <-timer.C:
+			// the hedge must not push the downstream over its limits, so it is skipped rather than waiting for them.
+			release := func() {}
+			if l := getDownstreamLimiter(rpcCallName); l != nil {
+				var err error
+				if release, err = l.tryAcquire(); err != nil {
+					continue
+				}
+			}
+			inflight++
+			if !isSkipMetrics() {
+				metricsProvider.IncreaseHedgeMetric(ctx, rpcCallName)
+			}
+			go call(true, release)
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if r.hedge && !isSkipMetrics() {
+					metricsProvider.IncreaseHedgeWinMetric(ctx, rpcCallName)
+				}
+				return r.resp, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			// hedging is for latency, not for retries: an error before the hedge is issued is returned as is.
+			if inflight == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
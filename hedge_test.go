@@ -0,0 +1,110 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgedCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		primary   time.Duration
+		primaryOk bool
+		hedge     time.Duration
+		resp      string
+		calls     int32
+		err       bool
+	}{
+		{
+			name:      "primary returns before the hedge delay",
+			primary:   0,
+			primaryOk: true,
+			resp:      "primary",
+			calls:     1,
+		}, {
+			name:      "hedge wins",
+			primary:   time.Second,
+			primaryOk: true,
+			hedge:     0,
+			resp:      "hedge",
+			calls:     2,
+		}, {
+			name:      "primary fails after the hedge is issued",
+			primary:   50 * time.Millisecond,
+			primaryOk: false,
+			hedge:     100 * time.Millisecond,
+			resp:      "hedge",
+			calls:     2,
+		}, {
+			name:      "primary fails before the hedge delay",
+			primary:   0,
+			primaryOk: false,
+			calls:     1,
+			err:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InjectHedgeConfig(nil, map[string]*HedgeConfig{"hedgedCall": {Delay: 20 * time.Millisecond}})
+			defer InjectHedgeConfig(nil, nil)
+			latencyTrackers.Delete("hedgedCall")
+
+			var calls int32
+			rpcCall := func(ctx context.Context) (*string, error) {
+				resp, delay, ok := "primary", tt.primary, tt.primaryOk
+				if atomic.AddInt32(&calls, 1) > 1 {
+					resp, delay, ok = "hedge", tt.hedge, true
+				}
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				if !ok {
+					return nil, errors.New("rpc failed")
+				}
+				return &resp, nil
+			}
+
+			resp, err := hedgedCall(context.Background(), "hedgedCall", rpcCall)
+			assert.Equal(t, tt.err, err != nil)
+			if !tt.err {
+				assert.Equal(t, tt.resp, *resp)
+			}
+			assert.Equal(t, tt.calls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestLatencyTrackerDelay(t *testing.T) {
+	cfg := &HedgeConfig{Percentile: 0.9, Delay: time.Second}
+	tracker := &latencyTracker{}
+	assert.Equal(t, time.Second, tracker.delay(cfg))
+
+	for i := 1; i <= 100; i++ {
+		tracker.record(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, 90*time.Millisecond, tracker.delay(cfg))
+}
@@ -22,6 +22,9 @@ import (
 
 	json "github.com/bytedance/sonic"
 	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/cache"
+	"github.com/bytedance/heimdall/constants"
 )
 
 var (
@@ -42,9 +45,10 @@ type CacheValue struct {
 
 func getData[response any](
 	ctx context.Context,
-	rpcCall func() (*response, error),
+	rpcCall func(ctx context.Context) (*response, error),
 	rpcCallName string,
 	cacheKey string,
+	previousKeys func() []string,
 	softTTL time.Duration,
 	hardTTL time.Duration,
 	readFromCache func() bool,
@@ -54,8 +58,13 @@ func getData[response any](
 	err error,
 ) {
 	if isSkipCache() {
-		return rpcCall()
+		return rpcCall(ctx)
+	}
+	calls, ok := enterCall()
+	if !ok {
+		return rpcCall(ctx)
 	}
+	defer calls.exit()
 
 	var result *CacheValue
 	if !readFromCache() {
@@ -66,7 +75,10 @@ func getData[response any](
 		return generateResp[response](result)
 	}
 
-	result, err = fetchFromCache(ctx, cacheKey)
+	result, err = fetchFromCache(ctx, cacheKey, rpcCallName)
+	if err != nil {
+		result, err = fetchFromPreviousVersions[response](ctx, cacheKey, previousKeys, hardTTL, rpcCallName)
+	}
 	if err != nil {
 		result, err = handleCacheMiss(ctx, cacheKey, rpcCall, softTTL, hardTTL, rpcCallName, writeToCache)
 		if err != nil {
@@ -74,6 +86,8 @@ func getData[response any](
 		}
 	}
 
+	trackRefreshAhead(ctx, cacheKey, rpcCall, softTTL, hardTTL, rpcCallName, writeToCache, result.UpdatedTS)
+
 	if isPastSoftTTLThreshhold(result) {
 		handleCacheSoftHit(ctx, cacheKey, rpcCall, softTTL, hardTTL, rpcCallName, writeToCache)
 	}
@@ -92,45 +106,79 @@ func generateResp[response any](cacheVal *CacheValue) (res *response, err error)
 	return resp, nil
 }
 
-func fetchFromCache(ctx context.Context, key string) (*CacheValue, error) {
+func fetchFromCache(ctx context.Context, key, rpcCallName string) (*CacheValue, error) {
 	cacheVal := &CacheValue{}
-	val, err := cacheProvider.Get(ctx, key)
+	val, err := cacheProvider.Get(cache.WithOperationName(ctx, rpcCallName), key)
 	if err != nil {
 		return nil, err
 	}
-	err = DecompressStruct(ctx, val, cacheVal, compressionLibrary)
+	err = decodeCacheValue(ctx, key, val, cacheVal, rpcCallName)
+	if errors.Is(err, ErrIntegrity) {
+		handleIntegrityFailure(ctx, key, val, rpcCallName)
+	}
 	return cacheVal, err
 }
 
-func handleCacheMiss[response any](ctx context.Context, key string, rpcCall func() (*response, error), softTTL,
+func handleCacheMiss[response any](ctx context.Context, key string, rpcCall func(ctx context.Context) (*response, error), softTTL,
 	hardTTL time.Duration, rpcCallName string, writeToCache func(*response) bool) (*CacheValue, error) {
 	if !isSkipMetrics() {
 		metricsProvider.IncreaseCacheMissMetric(ctx, rpcCallName)
 	}
 
-	resp, err := rpcCall()
+	release, err := acquireDownstream(ctx, rpcCallName)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hedgedCall(ctx, rpcCallName, rpcCall)
+	release()
 	if err != nil {
 		return nil, errors.Wrap(err, "rpc call failed")
 	}
 
-	go updateCache(ctx, key, resp, softTTL, hardTTL, writeToCache)
+	if isWarmUp(ctx) {
+		// a warm-up call is only done once its entry is in the cache
+		if err = writeWarmUpCache(ctx, key, resp, softTTL, hardTTL, rpcCallName, writeToCache); err != nil {
+			return nil, err
+		}
+	} else if admitWrite(ctx, key, rpcCallName) {
+		bgCtx := detachContext(ctx)
+		submitBackgroundTask(bgCtx, rpcCallName, func() {
+			writeCache(bgCtx, key, resp, softTTL, hardTTL, rpcCallName, writeToCache)
+		})
+	}
 	return makeCacheValue(resp, softTTL)
 }
 
-func handleCacheSoftHit[response any](ctx context.Context, key string, rpcCall func() (*response, error), softTTL,
+// handleCacheSoftHit refreshes the entry in the background while the stale entry is served. The downstream limiter is
+// only acquired once the refresh runs, so that time spent waiting for a worker does not hold downstream capacity.
+func handleCacheSoftHit[response any](ctx context.Context, key string, rpcCall func(ctx context.Context) (*response, error), softTTL,
 	hardTTL time.Duration, rpcCallName string, writeToCache func(*response) bool) {
 	if !isSkipMetrics() {
 		metricsProvider.IncreaseCacheSoftHitMetric(ctx, rpcCallName)
 	}
 
-	go func() {
-		resp, err := rpcCall()
-		if err != nil {
-			return // don't write to cache on error
-		}
+	bgCtx := detachContext(ctx)
+	submitBackgroundTask(bgCtx, rpcCallName, func() {
+		refreshWithRetries(bgCtx, rpcCallName, rpcCall, func(resp *response, err error) {
+			if errors.Is(err, ErrDownstreamLimited) {
+				// under StaleLimitPolicy, skipping the refresh is the expected outcome of being over the limit
+				if policy, _ := downstreamLimitPolicy(rpcCallName); policy != constants.StaleLimitPolicy {
+					reportError(bgCtx, rpcCallName, errors.Wrap(err, "soft ttl refresh skipped"))
+				}
+				return
+			}
+			if err != nil {
+				// don't write to cache on error
+				if !isSkipMetrics() {
+					metricsProvider.IncreaseRefreshErrorMetric(bgCtx, rpcCallName)
+				}
+				reportError(bgCtx, rpcCallName, errors.Wrap(err, "soft ttl refresh failed"))
+				return
+			}
 
-		updateCache(ctx, key, resp, softTTL, hardTTL, writeToCache)
-	}()
+			writeCache(bgCtx, key, resp, softTTL, hardTTL, rpcCallName, writeToCache)
+		})
+	})
 }
 
 func handleCacheHit(ctx context.Context, rpcCallName string) {
@@ -147,22 +195,47 @@ func generateResponseStructFromCacheVal[response any](cacheVal *CacheValue, res
 	return nil
 }
 
+// writeCache writes the rpc response to the cache in the background, bounded by the write timeout.
+// It reports whether the response was written.
+func writeCache[response any](ctx context.Context, key string, rpcCallResp *response, softTTL, hardTTL time.Duration,
+	rpcCallName string, writeToCache func(*response) bool) bool {
+	writeCtx, cancel := backgroundContext(ctx, writeTimeout)
+	defer cancel()
+
+	written, err := updateCache(writeCtx, key, rpcCallResp, softTTL, hardTTL, rpcCallName, writeToCache)
+	if errors.Is(err, cache.ErrCircuitOpen) {
+		// the cache is bypassed on purpose while the breaker is open, this is not a failure of the write
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseCacheWriteSkippedMetric(ctx, rpcCallName)
+		}
+	} else if err != nil {
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseCacheWriteErrorMetric(ctx, rpcCallName)
+		}
+		reportError(ctx, rpcCallName, err)
+	}
+	return written
+}
+
 func updateCache[response any](ctx context.Context, key string, rpcCallResp *response,
-	softTTL, hardTTL time.Duration, writeToCache func(*response) bool) {
+	softTTL, hardTTL time.Duration, rpcCallName string, writeToCache func(*response) bool) (bool, error) {
 	if rpcCallResp == nil || !writeToCache(rpcCallResp) {
-		return
+		return false, nil
 	}
 	cacheVal, err := makeCacheValue(rpcCallResp, softTTL)
 	if err != nil {
-		return
+		return false, err
 	}
-	compressedData, err := CompressStruct(ctx, cacheVal, compressionLibrary)
-	if err != nil {
-		return
+	if err = setCacheValue(ctx, key, cacheVal, hardTTL, rpcCallName); err != nil {
+		return false, err
 	}
-	err = cacheProvider.Set(ctx, key, compressedData, hardTTL)
-	if err != nil {
-		return
+	return true, nil
+}
+
+// reportError passes errors that cannot be returned to the caller to the error hook.
+func reportError(ctx context.Context, rpcCallName string, err error) {
+	if errorHook != nil {
+		errorHook(ctx, rpcCallName, err)
 	}
 }
 
@@ -171,7 +244,7 @@ func isPastSoftTTLThreshhold(cacheVal *CacheValue) bool {
 }
 
 func isSkipCache() bool {
-	return skipCache
+	return skipCache || isShuttingDown()
 }
 
 func isSkipMetrics() bool {
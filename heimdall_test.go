@@ -8,6 +8,7 @@ import (
 
 	"github.com/bytedance/heimdall/cache"
 	"github.com/bytedance/heimdall/constants"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,7 +28,7 @@ func TestGetData(t *testing.T) {
 	softTTL := 1 * time.Second
 	hardTTL := 2 * time.Second
 
-	rpcCall := func() (*string, error) {
+	rpcCall := func(ctx context.Context) (*string, error) {
 		resp := "response"
 		return &resp, nil
 	}
@@ -35,7 +36,7 @@ func TestGetData(t *testing.T) {
 	readFromCache := func() bool { return false }
 	writeToCache := func(resp *string) bool { return true }
 
-	res, err := getData(ctx, rpcCall, rpcCallName, cacheKey, softTTL, hardTTL, readFromCache, writeToCache)
+	res, err := getData(ctx, rpcCall, rpcCallName, cacheKey, nil, softTTL, hardTTL, readFromCache, writeToCache)
 	assert.NoError(t, err)
 	assert.Equal(t, "response", *res)
 }
@@ -71,7 +72,7 @@ func TestFetchFromCache(t *testing.T) {
 		GetAPI: mockClient,
 		SetAPI: mockClient,
 	}
-	result, err := fetchFromCache(ctx, key)
+	result, err := fetchFromCache(ctx, key, "rpcCallName")
 	assert.NoError(t, err)
 	assert.Equal(t, cacheVal, result)
 }
@@ -150,7 +151,7 @@ func TestHandleCacheHit(t *testing.T) {
 	softTTL := 1 * time.Second
 	hardTTL := 2 * time.Second
 
-	rpcCall := func() (*string, error) {
+	rpcCall := func(ctx context.Context) (*string, error) {
 		resp := "first response"
 		return &resp, nil
 	}
@@ -160,15 +161,16 @@ func TestHandleCacheHit(t *testing.T) {
 	writeToCache := func(resp *string) bool { return true }
 	ctx := context.Background()
 
+	waitForBackgroundTasks()
 	client := &MockedCache{}
 	cacheProvider = &cache.Client{
 		GetAPI: client,
 		SetAPI: client,
 	}
 
-	getData(ctx, rpcCall, rpcCallName, cacheKey, softTTL, hardTTL, readFromCache, writeToCache)
+	getData(ctx, rpcCall, rpcCallName, cacheKey, nil, softTTL, hardTTL, readFromCache, writeToCache)
 	time.Sleep(time.Second)
-	getData(ctx, rpcCall, rpcCallName, cacheKey, softTTL, hardTTL, readFromCache, writeToCache)
+	getData(ctx, rpcCall, rpcCallName, cacheKey, nil, softTTL, hardTTL, readFromCache, writeToCache)
 
 	expectedGet := 2
 	expectedSet := 1
@@ -200,3 +202,72 @@ func (m *MockedCache) Set(ctx context.Context, key string, val any, ttl time.Dur
 	m.NumSet++
 	return nil
 }
+
+func TestHandleCacheSoftHitDetachesContext(t *testing.T) {
+	waitForBackgroundTasks()
+	client := &MockedCache{}
+	cacheProvider = &cache.Client{
+		GetAPI: client,
+		SetAPI: client,
+	}
+
+	var hookErr error
+	InjectErrorHook(func(ctx context.Context, rpcCallName string, err error) {
+		hookErr = err
+	})
+	defer InjectErrorHook(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rpcCall := func(ctx context.Context) (*string, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp := "refreshed"
+		return &resp, nil
+	}
+	handleCacheSoftHit(ctx, "cacheKey", rpcCall, time.Second, 2*time.Second, "rpcCallName", func(*string) bool { return true })
+	cancel()
+	waitForBackgroundTasks()
+	assert.NoError(t, hookErr)
+	assert.Equal(t, 1, client.NumSet)
+
+	failingCall := func(ctx context.Context) (*string, error) {
+		return nil, errors.New("downstream unavailable")
+	}
+	handleCacheSoftHit(context.Background(), "cacheKey", failingCall, time.Second, 2*time.Second, "rpcCallName", func(*string) bool { return true })
+	waitForBackgroundTasks()
+	assert.Error(t, hookErr)
+	assert.Equal(t, 1, client.NumSet)
+}
+
+// failingSetCache misses on every read and fails every write with err.
+type failingSetCache struct {
+	mockedCache
+	err error
+}
+
+func (m *failingSetCache) Set(ctx context.Context, key string, val any, ttl time.Duration) error {
+	return m.err
+}
+
+func TestWriteCacheSkipsOpenCircuit(t *testing.T) {
+	var hookErr error
+	InjectErrorHook(func(ctx context.Context, rpcCallName string, err error) {
+		hookErr = err
+	})
+	defer InjectErrorHook(nil)
+
+	resp := "response"
+	client := &failingSetCache{err: errors.Wrap(cache.ErrCircuitOpen, "set")}
+	cacheProvider = &cache.Client{GetAPI: client, SetAPI: client}
+	written := writeCache(context.Background(), "cacheKey", &resp, time.Second, 2*time.Second, "rpcCallName",
+		func(*string) bool { return true })
+	assert.False(t, written)
+	assert.NoError(t, hookErr)
+
+	client.err = errors.New("cache unavailable")
+	written = writeCache(context.Background(), "cacheKey", &resp, time.Second, 2*time.Second, "rpcCallName",
+		func(*string) bool { return true })
+	assert.False(t, written)
+	assert.Error(t, hookErr)
+}
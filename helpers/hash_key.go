@@ -17,6 +17,7 @@
 package helpers
 
 import (
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/base64"
 	"fmt"
@@ -24,34 +25,62 @@ import (
 
 	json "github.com/bytedance/sonic"
 	"github.com/pkg/errors"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+
+	"github.com/bytedance/heimdall/constants"
 )
 
 // GenerateCacheKey generates a cache key for a given function name and request encoded in SHA512. With the following format:
-// SHA512(functionName:marshalledRequest:softTTL:hardTTL)
+// SHA512(functionName:marshalledRequest:softTTL:hardTTL:version)
 func GenerateCacheKey(req any, functionName string, softTTL, hardTTL time.Duration, version string) (string, error) {
-	var (
-		err           error
-		marshalledReq string
-	)
+	marshalledReq, err := MarshalRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	return HashKey(UnhashedCacheKey(functionName, marshalledReq, softTTL, hardTTL, version)), nil
+}
 
+// MarshalRequest marshals a request to JSON for key generation, with map keys sorted so that equal requests always
+// have the same key material.
+func MarshalRequest(req any) (string, error) {
 	if req == nil {
-		marshalledReq = ""
-	} else {
-		marshalledReq, err = json.ConfigStd.MarshalToString(req) // ensures Map's keys are sorted for unique key generation
-		if err != nil {
-			return "", errors.Wrap(err, "")
-		}
+		return "", nil
 	}
 
-	key := constructUnhashedKey(functionName, marshalledReq, softTTL, hardTTL, version)
+	marshalledReq, err := json.ConfigStd.MarshalToString(req) // ensures Map's keys are sorted for unique key generation
+	if err != nil {
+		return "", errors.Wrap(err, "")
+	}
+	return marshalledReq, nil
+}
 
-	hasher := sha512.New()
-	hasher.Write([]byte(key))
-	hashedKey := base64.URLEncoding.EncodeToString(hasher.Sum(nil))
+// UnhashedCacheKey returns the key material of a cache key before it is hashed, with the following format:
+// functionName:keyMaterial:softTTL:hardTTL:version
+func UnhashedCacheKey(functionName string, keyMaterial string, softTTL, hardTTL time.Duration, version string) string {
+	return fmt.Sprintf("%v:%v:%d:%d:%v", functionName, keyMaterial, int64(softTTL.Seconds()), int64(hardTTL.Seconds()), version)
+}
 
-	return hashedKey, nil
+// HashKey hashes unhashed key material with SHA512, encoded in URL safe base64.
+func HashKey(unhashedKey string) string {
+	return HashKeyWith(constants.SHA512KeyHasher, unhashedKey)
 }
 
-func constructUnhashedKey(functionName string, marshalledReq string, softTTL, hardTTL time.Duration, version string) string {
-	return fmt.Sprintf("%v:%v:%d:%d:%v", functionName, marshalledReq, int64(softTTL.Seconds()), int64(hardTTL.Seconds()), version)
+// HashKeyWith hashes unhashed key material with hasher. Only SHA512 keys are encoded with padding, for compatibility.
+func HashKeyWith(hasher constants.KeyHasherType, unhashedKey string) string {
+	switch hasher {
+	case constants.SHA256KeyHasher:
+		sum := sha256.Sum256([]byte(unhashedKey))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	case constants.XXH3KeyHasher:
+		sum := xxh3.HashString128(unhashedKey).Bytes()
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	case constants.BLAKE3KeyHasher:
+		sum := blake3.Sum256([]byte(unhashedKey))
+		return base64.RawURLEncoding.EncodeToString(sum[:])
+	default:
+		sum := sha512.Sum512([]byte(unhashedKey))
+		return base64.URLEncoding.EncodeToString(sum[:])
+	}
 }
@@ -19,10 +19,13 @@ package helpers
 import (
 	"crypto/sha512"
 	"encoding/base64"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
 )
 
 func TestGenerateCacheKey(t *testing.T) {
@@ -78,3 +81,57 @@ func hash(v string) string {
 	hasher.Write([]byte(v))
 	return base64.URLEncoding.EncodeToString(hasher.Sum(nil))
 }
+
+func TestHashKeyWith(t *testing.T) {
+	tests := []struct {
+		name   string
+		hasher constants.KeyHasherType
+		length int
+	}{
+		{
+			name:   "sha512",
+			hasher: constants.SHA512KeyHasher,
+			length: 88,
+		}, {
+			name:   "sha256",
+			hasher: constants.SHA256KeyHasher,
+			length: 43,
+		}, {
+			name:   "xxh3",
+			hasher: constants.XXH3KeyHasher,
+			length: 22,
+		}, {
+			name:   "blake3",
+			hasher: constants.BLAKE3KeyHasher,
+			length: 43,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := HashKeyWith(tt.hasher, "helloWorld:{}:1:2:v1.0.0")
+			assert.Len(t, key, tt.length)
+			assert.Equal(t, key, HashKeyWith(tt.hasher, "helloWorld:{}:1:2:v1.0.0"))
+			assert.NotEqual(t, key, HashKeyWith(tt.hasher, "helloWorld:{}:1:2:v1.0.1"))
+		})
+	}
+	assert.Equal(t, hash("helloWorld:{}:1:2:v1.0.0"), HashKey("helloWorld:{}:1:2:v1.0.0"))
+}
+
+func BenchmarkHashKeyWith(b *testing.B) {
+	unhashedKey := "helloWorld:" + strings.Repeat(`{"foo":"bar"}`, 32) + ":1:2:v1.0.0"
+	hashers := map[string]constants.KeyHasherType{
+		"sha512": constants.SHA512KeyHasher,
+		"sha256": constants.SHA256KeyHasher,
+		"xxh3":   constants.XXH3KeyHasher,
+		"blake3": constants.BLAKE3KeyHasher,
+	}
+	for name, hasher := range hashers {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				HashKeyWith(hasher, unhashedKey)
+			}
+		})
+	}
+}
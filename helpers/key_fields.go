@@ -0,0 +1,93 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"strings"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+)
+
+// sortedJSONAPI keeps numbers as they are, so that large integers are not rounded into the same key.
+var sortedJSONAPI = json.Config{SortMapKeys: true, UseNumber: true, EscapeHTML: true}.Froze()
+
+// SelectFields keeps the include fields of a JSON object, if there are any, then removes its exclude fields. Fields are
+// dot separated paths of JSON field names, such as "user.id". Values that are not JSON objects are returned as they are.
+func SelectFields(marshalled string, include, exclude []string) (string, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return marshalled, nil
+	}
+
+	var v any
+	if err := sortedJSONAPI.UnmarshalFromString(marshalled, &v); err != nil {
+		return "", errors.Wrap(err, "unable to unmarshal request for field selection")
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return marshalled, nil
+	}
+
+	if len(include) > 0 {
+		selected := map[string]any{}
+		for _, field := range include {
+			copyPath(obj, selected, strings.Split(field, "."))
+		}
+		obj = selected
+	}
+	for _, field := range exclude {
+		deletePath(obj, strings.Split(field, "."))
+	}
+
+	selected, err := sortedJSONAPI.MarshalToString(obj)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal request for field selection")
+	}
+	return selected, nil
+}
+
+func copyPath(src, dst map[string]any, path []string) {
+	v, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = v
+		return
+	}
+
+	srcChild, ok := v.(map[string]any)
+	if !ok {
+		return
+	}
+	dstChild, ok := dst[path[0]].(map[string]any)
+	if !ok {
+		dstChild = map[string]any{}
+		dst[path[0]] = dstChild
+	}
+	copyPath(srcChild, dstChild, path[1:])
+}
+
+func deletePath(obj map[string]any, path []string) {
+	if len(path) == 1 {
+		delete(obj, path[0])
+		return
+	}
+	if child, ok := obj[path[0]].(map[string]any); ok {
+		deletePath(child, path[1:])
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectFields(t *testing.T) {
+	req := `{"request_id":"abc","user":{"id":12345678901234567890,"name":"foo"},"page":1}`
+	tests := []struct {
+		name     string
+		req      string
+		include  []string
+		exclude  []string
+		selected string
+	}{
+		{
+			name:     "no selection",
+			req:      req,
+			selected: req,
+		}, {
+			name:     "include",
+			req:      req,
+			include:  []string{"user.id", "page", "missing"},
+			selected: `{"page":1,"user":{"id":12345678901234567890}}`,
+		}, {
+			name:     "exclude",
+			req:      req,
+			exclude:  []string{"request_id", "user.name"},
+			selected: `{"page":1,"user":{"id":12345678901234567890}}`,
+		}, {
+			name:     "include and exclude",
+			req:      req,
+			include:  []string{"user"},
+			exclude:  []string{"user.name"},
+			selected: `{"user":{"id":12345678901234567890}}`,
+		}, {
+			name:     "not an object",
+			req:      "null",
+			exclude:  []string{"request_id"},
+			selected: "null",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := SelectFields(tt.req, tt.include, tt.exclude)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.selected, selected)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var protoJSON = protojson.MarshalOptions{UseProtoNames: true}
+
+// MarshalProtoRequest canonicalizes a protobuf request for key generation. The request is encoded with the protobuf
+// JSON mapping, whose output is specified but not stable byte for byte, then re-encoded as JSON with sorted field names
+// and no whitespace. Unlike JSON marshalling of the generated struct or binary protobuf marshalling, it only depends on
+// the fields set in the message and not on the code generator or protobuf runtime version: unknown fields are
+// discarded, and fields set to their default value without explicit presence are left out. The include and exclude
+// fields are dot separated protobuf field names or JSON names, such as "user.id", and are applied as in SelectFields.
+func MarshalProtoRequest(msg proto.Message, include, exclude []string) (string, error) {
+	if msg == nil || !msg.ProtoReflect().IsValid() {
+		return "", nil
+	}
+
+	msg = proto.Clone(msg)
+	if len(include) > 0 {
+		selected := msg.ProtoReflect().New()
+		for _, field := range include {
+			copyProtoPath(msg.ProtoReflect(), selected, strings.Split(field, "."))
+		}
+		msg = selected.Interface()
+	}
+	for _, field := range exclude {
+		clearProtoPath(msg.ProtoReflect(), strings.Split(field, "."))
+	}
+
+	b, err := protoJSON.Marshal(msg)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal protobuf request")
+	}
+	var canonical any
+	if err = sortedJSONAPI.Unmarshal(b, &canonical); err != nil {
+		return "", errors.Wrap(err, "unable to unmarshal protobuf request")
+	}
+	s, err := sortedJSONAPI.MarshalToString(canonical)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to canonicalize protobuf request")
+	}
+	return s, nil
+}
+
+func findProtoField(m protoreflect.Message, name string) protoreflect.FieldDescriptor {
+	fields := m.Descriptor().Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+func copyProtoPath(src, dst protoreflect.Message, path []string) {
+	fd := findProtoField(src, path[0])
+	if fd == nil || !src.Has(fd) {
+		return
+	}
+	if len(path) == 1 {
+		dst.Set(fd, src.Get(fd))
+		return
+	}
+
+	if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+		return
+	}
+	copyProtoPath(src.Get(fd).Message(), dst.Mutable(fd).Message(), path[1:])
+}
+
+func clearProtoPath(m protoreflect.Message, path []string) {
+	fd := findProtoField(m, path[0])
+	if fd == nil || !m.Has(fd) {
+		return
+	}
+	if len(path) == 1 {
+		m.Clear(fd)
+		return
+	}
+
+	if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+		return
+	}
+	clearProtoPath(m.Mutable(fd).Message(), path[1:])
+}
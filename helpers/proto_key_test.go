@@ -0,0 +1,104 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestMarshalProtoRequest(t *testing.T) {
+	base := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("user_id"),
+		Number:   proto.Int32(1),
+		JsonName: proto.String("userId"),
+		Options:  &descriptorpb.FieldOptions{Deprecated: proto.Bool(true), Lazy: proto.Bool(true)},
+	}
+	withUnknown := proto.Clone(base).(*descriptorpb.FieldDescriptorProto)
+	unknown := protowire.AppendTag(nil, 1000, protowire.VarintType)
+	withUnknown.ProtoReflect().SetUnknown(protowire.AppendVarint(unknown, 42))
+	withUnknown.Options.ProtoReflect().SetUnknown(protowire.AppendVarint(unknown, 42))
+	otherNumber := proto.Clone(base).(*descriptorpb.FieldDescriptorProto)
+	otherNumber.Number = proto.Int32(2)
+	otherLazy := proto.Clone(base).(*descriptorpb.FieldDescriptorProto)
+	otherLazy.Options.Lazy = proto.Bool(false)
+
+	tests := []struct {
+		name    string
+		other   proto.Message
+		include []string
+		exclude []string
+		same    bool
+	}{
+		{
+			name:  "unknown fields",
+			other: withUnknown,
+			same:  true,
+		}, {
+			name:  "different field",
+			other: otherNumber,
+			same:  false,
+		}, {
+			name:    "excluded field",
+			other:   otherNumber,
+			exclude: []string{"number"},
+			same:    true,
+		}, {
+			name:    "excluded nested field",
+			other:   otherLazy,
+			exclude: []string{"options.lazy"},
+			same:    true,
+		}, {
+			name:    "included fields",
+			other:   otherLazy,
+			include: []string{"name", "options.deprecated"},
+			same:    true,
+		}, {
+			name:    "included field differs",
+			other:   otherLazy,
+			include: []string{"options"},
+			same:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := MarshalProtoRequest(base, tt.include, tt.exclude)
+			assert.NoError(t, err)
+			got, err := MarshalProtoRequest(tt.other, tt.include, tt.exclude)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.same, want == got)
+		})
+	}
+
+	// the key material is the protobuf JSON mapping with sorted field names.
+	canonical, err := MarshalProtoRequest(base, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"json_name":"userId","name":"user_id","number":1,"options":{"deprecated":true,"lazy":true}}`, canonical)
+
+	// the request is not modified.
+	assert.Len(t, withUnknown.ProtoReflect().GetUnknown(), len(unknown)+1)
+	assert.True(t, base.Options.GetLazy())
+
+	empty, err := MarshalProtoRequest((*descriptorpb.FieldDescriptorProto)(nil), nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "", empty)
+}
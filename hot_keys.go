@@ -0,0 +1,30 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"github.com/bytedance/heimdall/cache"
+)
+
+// HotKeys returns up to n of the most read cache keys, most read first, and whether they are currently served from
+// the local cache. It returns nil if hot key detection is not enabled in the cache config.
+func HotKeys(n int) []cache.HotKeyStat {
+	if cacheProvider == nil {
+		return nil
+	}
+	return cacheProvider.HotKeys(n)
+}
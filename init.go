@@ -17,6 +17,8 @@
 package heimdall
 
 import (
+	"context"
+	"strings"
 	"sync"
 	"time"
 
@@ -77,12 +79,107 @@ type Config struct {
 	SkipCache bool `json:"skip_cache,omitempty" yaml:"skip_cache,omitempty" xml:"skip_cache,omitempty"`
 
 	// CompressLibrary is a toggle to enable or disable library compression of choice.
-	// Currently we support GZIP and Snappy compression, both of which should be used in different use cases.
+	// Currently we support GZIP, Snappy, Zstd, LZ4 and Brotli compression, which should be used in different use cases.
+	// Custom compressors registered with RegisterCompressor are selected by their ID.
 	CompressionLibrary constants.CompressionLibraryType `json:"compress_library,omitempty" yaml:"compress_library,omitempty" xml:"compress_library,omitempty"`
 
 	// Version is the version of cache you wish to use. This will be appended to the key name.
 	// If there are any upgrades, this prevents breaking changes as old keys will not be re-used
 	Version string `json:"version,omitempty" yaml:"version,omitempty" xml:"version,omitempty"`
+
+	// CompressionPolicy stores small entries uncompressed and chooses the compression library of larger entries by
+	// their size. Setting it records the library in every entry. This field is optional.
+	CompressionPolicy *CompressionPolicy `json:"compression_policy,omitempty" yaml:"compression_policy,omitempty" xml:"compression_policy,omitempty"`
+
+	// KeyProvider encrypts entries with AES-GCM after compression, for responses that contain sensitive data. The id of
+	// the key is stored in every entry, so keys can be rotated. Entries that cannot be decrypted are treated as misses.
+	// This field is optional.
+	KeyProvider KeyProvider `json:"-" yaml:"-" xml:"-"`
+	// RejectUnencryptedEntries treats entries that are not encrypted as misses when KeyProvider is set. It is always on
+	// unless IntegrityConfig signs entries with an HMACSecret, as only signed entries are accepted then.
+	RejectUnencryptedEntries bool `json:"reject_unencrypted_entries,omitempty" yaml:"reject_unencrypted_entries,omitempty" xml:"reject_unencrypted_entries,omitempty"`
+
+	// IntegrityConfig makes every entry carry a checksum or an HMAC signature, verified before the entry is decoded.
+	// Entries that fail verification are treated as misses. This field is optional.
+	IntegrityConfig *IntegrityConfig `json:"integrity_config,omitempty" yaml:"integrity_config,omitempty" xml:"integrity_config,omitempty"`
+
+	// KeyHasher is the hash function of cache keys. Defaults to SHA512KeyHasher; the other hashers are faster and
+	// generate shorter keys. Changing it changes every key.
+	KeyHasher constants.KeyHasherType `json:"key_hasher,omitempty" yaml:"key_hasher,omitempty" xml:"key_hasher,omitempty"`
+	// Namespace isolates the keys of a service sharing a cache with other services. If it is set, keys are
+	// heimdall:<Namespace>:<Version>:<rpc call name>:<hash>, so that the keys of a service or a method can be
+	// inspected, measured and flushed with SCAN. It cannot contain ':', '{' or '}'. It is required by the call journal
+	// of WarmConfig, and optional otherwise.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty" xml:"namespace,omitempty"`
+	// MethodHashTags co-locates the keys of the methods set to true in the same Redis Cluster hash slot, by wrapping
+	// the rpc call name of their keys in a hash tag. This concentrates the load of a method on a single node, so only
+	// use it for methods whose keys are used together. It requires a Namespace. The keys are the rpc call names.
+	// This field is optional.
+	MethodHashTags map[string]bool `json:"method_hash_tags,omitempty" yaml:"method_hash_tags,omitempty" xml:"method_hash_tags,omitempty"`
+
+	// KeyConfig sets how the cache keys of every method without an entry in MethodKeyConfigs are generated, for
+	// example leaving request ids out of the keys. This field is optional.
+	KeyConfig *KeyConfig `json:"key_config,omitempty" yaml:"key_config,omitempty" xml:"key_config,omitempty"`
+	// MethodKeyConfigs overrides KeyConfig per method. The keys are the rpc call names. This field is optional.
+	MethodKeyConfigs map[string]*KeyConfig `json:"method_key_configs,omitempty" yaml:"method_key_configs,omitempty" xml:"method_key_configs,omitempty"`
+
+	// ZstdConfig sets the level and the per-method dictionaries of ZstdCompressionType. This field is optional.
+	ZstdConfig *ZstdConfig `json:"zstd_config,omitempty" yaml:"zstd_config,omitempty" xml:"zstd_config,omitempty"`
+
+	// PreviousVersions are checked, in order, on a miss under Version. An entry found under a previous version is served
+	// if its payload still decodes into the response type, so that versions can be rolled without a cold cache.
+	// Every previous version adds a cache read to misses. This field is optional.
+	PreviousVersions []string `json:"previous_versions,omitempty" yaml:"previous_versions,omitempty" xml:"previous_versions,omitempty"`
+	// RewritePreviousVersions writes the entries served from a previous version under Version as well, keeping their age.
+	RewritePreviousVersions bool `json:"rewrite_previous_versions,omitempty" yaml:"rewrite_previous_versions,omitempty" xml:"rewrite_previous_versions,omitempty"`
+
+	// WorkerPoolConfig is the configuration for the bounded worker pool that runs background cache writes and
+	// soft TTL refreshes. If this is not set, a worker pool with default settings is used.
+	WorkerPoolConfig *WorkerPoolConfig `json:"worker_pool_config,omitempty" yaml:"worker_pool_config,omitempty" xml:"worker_pool_config,omitempty"`
+
+	// RefreshTimeout bounds the RPC call made in the background to refresh an entry past its soft TTL.
+	// Background work is detached from the caller's context cancellation, so this is the only limit on it. Defaults to 10 seconds.
+	RefreshTimeout time.Duration `json:"refresh_timeout,omitempty" yaml:"refresh_timeout,omitempty" xml:"refresh_timeout,omitempty"`
+	// RefreshRetryConfig enables retries with exponential backoff for failed background soft TTL refreshes.
+	// Each attempt is bounded by RefreshTimeout. This field is optional.
+	RefreshRetryConfig *RetryConfig `json:"refresh_retry_config,omitempty" yaml:"refresh_retry_config,omitempty" xml:"refresh_retry_config,omitempty"`
+	// WriteTimeout bounds a background cache write. Defaults to 2 seconds.
+	WriteTimeout time.Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty" xml:"write_timeout,omitempty"`
+	// ErrorHook is called with errors from background refreshes and cache writes. This field is optional.
+	ErrorHook ErrorHook `json:"-" yaml:"-" xml:"-"`
+
+	// DownstreamLimitConfig limits the concurrency and rate of the calls made to downstream services on cache misses
+	// and soft TTL refreshes. It applies to every method without an entry in MethodDownstreamLimitConfigs. This field is optional.
+	DownstreamLimitConfig *DownstreamLimitConfig `json:"downstream_limit_config,omitempty" yaml:"downstream_limit_config,omitempty" xml:"downstream_limit_config,omitempty"`
+	// MethodDownstreamLimitConfigs overrides DownstreamLimitConfig per method. The keys are the rpc call names,
+	// which are the same names the metrics are emitted with. This field is optional.
+	MethodDownstreamLimitConfigs map[string]*DownstreamLimitConfig `json:"method_downstream_limit_configs,omitempty" yaml:"method_downstream_limit_configs,omitempty" xml:"method_downstream_limit_configs,omitempty"`
+
+	// HedgeConfig enables hedged downstream calls on cache misses for every method without an entry in
+	// MethodHedgeConfigs. Only set this if all cached calls are idempotent. This field is optional.
+	HedgeConfig *HedgeConfig `json:"hedge_config,omitempty" yaml:"hedge_config,omitempty" xml:"hedge_config,omitempty"`
+	// MethodHedgeConfigs enables hedged downstream calls per method. The keys are the rpc call names. A nil value
+	// disables hedging for the method. This field is optional.
+	MethodHedgeConfigs map[string]*HedgeConfig `json:"method_hedge_configs,omitempty" yaml:"method_hedge_configs,omitempty" xml:"method_hedge_configs,omitempty"`
+
+	// RefreshAheadConfig enables refreshing frequently accessed keys before their hard TTL, even if no request arrives
+	// in their soft TTL window. This field is optional.
+	RefreshAheadConfig *RefreshAheadConfig `json:"refresh_ahead_config,omitempty" yaml:"refresh_ahead_config,omitempty" xml:"refresh_ahead_config,omitempty"`
+
+	// WarmConfig configures Warm and the journal of recently seen calls used to warm the next version up.
+	// This field is optional.
+	WarmConfig *WarmConfig `json:"warm_config,omitempty" yaml:"warm_config,omitempty" xml:"warm_config,omitempty"`
+
+	// AdmissionConfig only writes an entry to the cache once its key has been missed a number of times within a window,
+	// so that one-off requests are not cached. This field is optional.
+	AdmissionConfig *AdmissionConfig `json:"admission_config,omitempty" yaml:"admission_config,omitempty" xml:"admission_config,omitempty"`
+
+	// EntrySizeConfig limits the size of the entries written to the cache for every method without an entry in
+	// MethodEntrySizeConfigs. This field is optional.
+	EntrySizeConfig *EntrySizeConfig `json:"entry_size_config,omitempty" yaml:"entry_size_config,omitempty" xml:"entry_size_config,omitempty"`
+	// MethodEntrySizeConfigs overrides EntrySizeConfig per method. The keys are the rpc call names. A nil value removes
+	// the limit for the method. This field is optional.
+	MethodEntrySizeConfigs map[string]*EntrySizeConfig `json:"method_entry_size_configs,omitempty" yaml:"method_entry_size_configs,omitempty" xml:"method_entry_size_configs,omitempty"`
 }
 
 func (c *Config) freeze() error {
@@ -95,6 +192,10 @@ func (c *Config) freeze() error {
 		return err
 	}
 
+	if err = InjectZstdConfig(c.ZstdConfig); err != nil {
+		return err
+	}
+
 	var metricsProv *metrics.Client
 	if c.EnableMetricsEmission && c.MetricsConfig != nil {
 		if metricsProv, err = c.MetricsConfig.Freeze(); err != nil {
@@ -102,13 +203,49 @@ func (c *Config) freeze() error {
 		}
 	}
 
+	cacheProv.OnCircuitStateChange(func(from, to cache.CircuitState) {
+		if !isSkipMetrics() {
+			metricsProvider.EmitCacheCircuitStateChangeMetric(context.Background(), from.String(), to.String())
+		}
+	})
+	cacheProv.OnHotKeyEvent(func(event cache.HotKeyEvent, key, rpcCallName string) {
+		if isSkipMetrics() {
+			return
+		}
+		switch event {
+		case cache.HotKeyDetected:
+			metricsProvider.IncreaseHotKeyDetectedMetric(context.Background(), rpcCallName, key)
+		case cache.HotKeyLocalHit:
+			metricsProvider.IncreaseHotKeyLocalHitMetric(context.Background(), rpcCallName)
+		}
+	})
+
 	InjectSoftTTL(c.DefaultSoftTTL)
 	InjectHardTTL(c.DefaultHardTTL)
 	InjectCacheProvider(cacheProv)
 	InjectMetricsProvider(metricsProv)
 	InjectSkipCache(c.SkipCache)
 	InjectCompressionLibrary(c.CompressionLibrary)
+	InjectCompressionPolicy(c.CompressionPolicy)
+	InjectKeyProvider(c.KeyProvider, c.RejectUnencryptedEntries)
+	InjectIntegrityConfig(c.IntegrityConfig)
 	InjectVersion(c.Version)
+	InjectKeyConfig(c.KeyConfig, c.MethodKeyConfigs)
+	InjectKeyFormat(c.KeyHasher, c.Namespace)
+	InjectMethodHashTags(c.MethodHashTags)
+	InjectPreviousVersions(c.PreviousVersions, c.RewritePreviousVersions)
+	InjectWorkerPoolConfig(c.WorkerPoolConfig)
+	InjectRefreshTimeout(c.RefreshTimeout)
+	InjectWriteTimeout(c.WriteTimeout)
+	InjectRefreshRetryConfig(c.RefreshRetryConfig)
+	InjectErrorHook(c.ErrorHook)
+	InjectDownstreamLimitConfig(c.DownstreamLimitConfig, c.MethodDownstreamLimitConfigs)
+	InjectHedgeConfig(c.HedgeConfig, c.MethodHedgeConfigs)
+	InjectRefreshAheadConfig(c.RefreshAheadConfig)
+	InjectWarmConfig(c.WarmConfig)
+	InjectAdmissionConfig(c.AdmissionConfig)
+	InjectEntrySizeConfig(c.EntrySizeConfig, c.MethodEntrySizeConfigs)
+	startLifecycle()
 
 	return nil
 }
@@ -133,10 +270,99 @@ func (c *Config) validate() error {
 	// 0 - No compression
 	// 1 - Gzip compression
 	// 2 - Snappy compression
+	// 3 - Zstd compression
+	// 4 - LZ4 compression
+	// 5 - Brotli compression
+	// 100 onwards - custom compressors registered with RegisterCompressor
 
-	if c.CompressionLibrary < 0 || c.CompressionLibrary > 3 {
+	if _, err := getCompressor(c.CompressionLibrary); err != nil {
 		return errors.Errorf("invalid compression library type specified.")
 	}
+
+	if c.KeyHasher < constants.SHA512KeyHasher || c.KeyHasher > constants.BLAKE3KeyHasher {
+		return errors.Errorf("invalid key hasher type specified.")
+	}
+
+	if strings.ContainsAny(c.Namespace, ":{}") {
+		return errors.Errorf("namespace cannot contain ':', '{' or '}'")
+	}
+	if c.Namespace == "" && len(c.MethodHashTags) > 0 {
+		return errors.Errorf("method hash tags require a namespace")
+	}
+	if c.Namespace == "" && c.WarmConfig != nil && c.WarmConfig.JournalSize > 0 {
+		return errors.Errorf("the call journal requires a namespace identifying the service")
+	}
+
+	if err := c.KeyConfig.validate(); err != nil {
+		return err
+	}
+	for _, methodCfg := range c.MethodKeyConfigs {
+		if err := methodCfg.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.CompressionPolicy.validate(); err != nil {
+		return err
+	}
+
+	if err := c.ZstdConfig.validate(); err != nil {
+		return err
+	}
+
+	if c.RefreshTimeout < 0 || c.WriteTimeout < 0 {
+		return errors.Errorf("background timeouts cannot be negative")
+	}
+
+	if err := c.RefreshRetryConfig.validate(); err != nil {
+		return err
+	}
+
+	if err := c.WorkerPoolConfig.validate(); err != nil {
+		return err
+	}
+
+	if err := c.DownstreamLimitConfig.validate(); err != nil {
+		return err
+	}
+
+	for _, methodCfg := range c.MethodDownstreamLimitConfigs {
+		if err := methodCfg.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.HedgeConfig.validate(); err != nil {
+		return err
+	}
+
+	for _, methodCfg := range c.MethodHedgeConfigs {
+		if err := methodCfg.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.RefreshAheadConfig.validate(); err != nil {
+		return err
+	}
+
+	if err := c.WarmConfig.validate(); err != nil {
+		return err
+	}
+
+	if err := c.AdmissionConfig.validate(); err != nil {
+		return err
+	}
+
+	if err := c.EntrySizeConfig.validate(); err != nil {
+		return err
+	}
+
+	for _, methodCfg := range c.MethodEntrySizeConfigs {
+		if err := methodCfg.validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/cache"
+)
+
+var (
+	integrityConfig *IntegrityConfig
+
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrIntegrity is returned when an entry fails its checksum or signature verification. Such entries are treated
+	// as misses.
+	ErrIntegrity = errors.New("cache entry failed integrity verification")
+)
+
+// IntegrityConfig makes every entry carry a CRC32C checksum, or an HMAC-SHA256 signature if HMACSecret is set, which is
+// verified before the entry is decoded. Entries that fail verification are treated as misses.
+type IntegrityConfig struct {
+	// HMACSecret signs entries with HMAC-SHA256 instead of a checksum. Use a secret per service sharing a cache, so
+	// that a service never serves the entries of another service written under the same key. Entries without a valid
+	// signature, including entries written before signing was enabled, are rejected. This field is optional.
+	HMACSecret []byte `json:"-" yaml:"-" xml:"-"`
+	// DeleteCorrupted deletes the entries that fail verification, if the cache client implements cache.IDelete.
+	DeleteCorrupted bool `json:"delete_corrupted,omitempty" yaml:"delete_corrupted,omitempty" xml:"delete_corrupted,omitempty"`
+}
+
+// InjectIntegrityConfig sets the integrity configuration. A nil config disables checksums and signatures.
+func InjectIntegrityConfig(cfg *IntegrityConfig) {
+	integrityConfig = cfg
+}
+
+// integrityFlag returns the envelope flag of the checksum or signature written with new entries, or 0 if there is none.
+func integrityFlag() byte {
+	cfg := integrityConfig
+	switch {
+	case cfg == nil:
+		return 0
+	case len(cfg.HMACSecret) > 0:
+		return flagHMAC
+	default:
+		return flagChecksum
+	}
+}
+
+// integritySum returns the checksum or signature of data for flag. Signatures also cover the key the entry is stored
+// under, so that a signed entry copied under another key is rejected.
+func integritySum(flag byte, key string, data []byte) []byte {
+	if flag == flagHMAC {
+		mac := hmac.New(sha256.New, integrityConfig.HMACSecret)
+		keyLen := make([]byte, 4)
+		binary.BigEndian.PutUint32(keyLen, uint32(len(key)))
+		mac.Write(keyLen)
+		mac.Write([]byte(key))
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+	sum := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(data, crc32cTable))
+	return sum
+}
+
+func integritySumSize(flag byte) int {
+	if flag == flagHMAC {
+		return sha256.Size
+	}
+	return crc32.Size
+}
+
+// verifyIntegrity checks the checksum or signature trailing the payload of e, read under key, and strips it. Every
+// failure wraps ErrIntegrity.
+func verifyIntegrity(e *envelope, key string) error {
+	want := integrityFlag()
+	got := e.flags & (flagChecksum | flagHMAC)
+	if got == 0 {
+		if want == flagHMAC {
+			return errors.Wrap(ErrIntegrity, "entry is not signed")
+		}
+		return nil
+	}
+	if got == flagHMAC && want != flagHMAC {
+		return errors.Wrap(ErrIntegrity, "entry is signed but there is no HMAC secret")
+	}
+	if got == flagChecksum && want == flagHMAC {
+		return errors.Wrap(ErrIntegrity, "entry is not signed")
+	}
+
+	size := integritySumSize(got)
+	if len(e.payload) < size {
+		return errors.Wrap(ErrIntegrity, "entry is truncated")
+	}
+	payload, sum := e.payload[:len(e.payload)-size], e.payload[len(e.payload)-size:]
+	if !hmac.Equal(sum, integritySum(got, key, append(e.header(), payload...))) {
+		return errors.Wrap(ErrIntegrity, "checksum mismatch")
+	}
+	e.payload = payload
+	return nil
+}
+
+// handleIntegrityFailure counts an entry that failed verification and, if configured to, deletes it in the background
+// unless it has been overwritten since it was read.
+func handleIntegrityFailure(ctx context.Context, key string, bad []byte, rpcCallName string) {
+	if !isSkipMetrics() {
+		metricsProvider.IncreaseIntegrityFailureMetric(ctx, rpcCallName)
+	}
+	cfg := integrityConfig
+	if cfg == nil || !cfg.DeleteCorrupted {
+		return
+	}
+
+	submitBackgroundTask(ctx, rpcCallName, func() {
+		ctx, cancel := backgroundContext(ctx, writeTimeout)
+		defer cancel()
+
+		// the entry is treated as a miss whether or not it could be deleted. Reading it again narrows, but does not
+		// close, the window in which a valid entry written meanwhile is deleted.
+		current, err := cacheProvider.Get(ctx, key)
+		if err != nil || !bytes.Equal(current, bad) {
+			return
+		}
+		if err = cacheProvider.Delete(ctx, key); err != nil && !errors.Is(err, cache.ErrDeleteNotSupported) {
+			reportError(ctx, rpcCallName, errors.Wrap(err, "unable to delete corrupted entry"))
+		}
+	})
+}
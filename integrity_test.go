@@ -0,0 +1,136 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntegrity(t *testing.T) {
+	corrupt := func(data map[string]any) {
+		val := append([]byte(nil), data["key"].([]byte)...)
+		val[envelopeHeaderSize] ^= 1
+		data["key"] = val
+	}
+	tests := []struct {
+		name    string
+		write   *IntegrityConfig
+		read    *IntegrityConfig
+		mutate  func(data map[string]any)
+		err     error
+		deleted bool
+	}{
+		{
+			name:  "checksum",
+			write: &IntegrityConfig{},
+			read:  &IntegrityConfig{},
+		}, {
+			name:   "corrupted checksum",
+			write:  &IntegrityConfig{},
+			read:   &IntegrityConfig{},
+			mutate: corrupt,
+			err:    ErrIntegrity,
+		}, {
+			name:    "corrupted checksum deleted",
+			write:   &IntegrityConfig{},
+			read:    &IntegrityConfig{DeleteCorrupted: true},
+			mutate:  corrupt,
+			err:     ErrIntegrity,
+			deleted: true,
+		}, {
+			name:  "entry without checksum",
+			write: nil,
+			read:  &IntegrityConfig{},
+		}, {
+			name:  "hmac",
+			write: &IntegrityConfig{HMACSecret: []byte("secret")},
+			read:  &IntegrityConfig{HMACSecret: []byte("secret")},
+		}, {
+			name:  "hmac of another service",
+			write: &IntegrityConfig{HMACSecret: []byte("other secret")},
+			read:  &IntegrityConfig{HMACSecret: []byte("secret")},
+			err:   ErrIntegrity,
+		}, {
+			name:  "hmac of another key",
+			write: &IntegrityConfig{HMACSecret: []byte("secret")},
+			read:  &IntegrityConfig{HMACSecret: []byte("secret")},
+			mutate: func(data map[string]any) {
+				_ = setCacheValue(context.Background(), "other", &CacheValue{Data: "other"}, time.Minute, "rpcCallName")
+				data["key"] = data["other"]
+			},
+			err: ErrIntegrity,
+		}, {
+			name:  "unsigned entry",
+			write: nil,
+			read:  &IntegrityConfig{HMACSecret: []byte("secret")},
+			err:   ErrIntegrity,
+		}, {
+			name:  "checksummed entry",
+			write: &IntegrityConfig{},
+			read:  &IntegrityConfig{HMACSecret: []byte("secret")},
+			err:   ErrIntegrity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]any{}
+			mockCache(data)
+			t.Cleanup(func() { InjectIntegrityConfig(nil) })
+
+			ctx := context.Background()
+			cacheVal := &CacheValue{UpdatedTS: time.Now().Unix(), Data: "data"}
+			InjectIntegrityConfig(tt.write)
+			assert.NoError(t, setCacheValue(ctx, "key", cacheVal, time.Minute, "rpcCallName"))
+			if tt.mutate != nil {
+				tt.mutate(data)
+			}
+
+			InjectIntegrityConfig(tt.read)
+			got, err := fetchFromCache(ctx, "key", "rpcCallName")
+			waitForBackgroundTasks()
+			if tt.err != nil {
+				assert.True(t, errors.Is(err, tt.err))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, cacheVal, got)
+			}
+			_, stored := data["key"]
+			assert.Equal(t, !tt.deleted, stored)
+		})
+	}
+}
+
+func TestIntegrityFailureKeepsOverwrittenEntry(t *testing.T) {
+	data := map[string]any{"key": []byte("overwritten")}
+	mockCache(data)
+	InjectIntegrityConfig(&IntegrityConfig{DeleteCorrupted: true})
+	t.Cleanup(func() { InjectIntegrityConfig(nil) })
+
+	handleIntegrityFailure(context.Background(), "key", []byte("corrupted"), "rpcCallName")
+	waitForBackgroundTasks()
+	assert.Equal(t, []byte("overwritten"), data["key"])
+
+	handleIntegrityFailure(context.Background(), "key", []byte("overwritten"), "rpcCallName")
+	waitForBackgroundTasks()
+	assert.NotContains(t, data, "key")
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+)
+
+const (
+	// journalKeyPrefix is outside of the heimdall:<namespace>: keyspace, so that flushing the entries of a service
+	// with ScanPattern leaves its journal alone.
+	journalKeyPrefix            = "heimdall-journal:"
+	defaultJournalFlushInterval = 30 * time.Second
+	defaultJournalTTL           = 7 * 24 * time.Hour
+)
+
+var (
+	// callJournalMu guards callJournal, which calls read while Shutdown or Init replace it.
+	callJournalMu sync.RWMutex
+	callJournal   *recentCallJournal
+)
+
+// swapCallJournal replaces the call journal and returns the previous one, which the caller must stop.
+func swapCallJournal(j *recentCallJournal) *recentCallJournal {
+	callJournalMu.Lock()
+	defer callJournalMu.Unlock()
+	old := callJournal
+	callJournal = j
+	return old
+}
+
+func getCallJournal() *recentCallJournal {
+	callJournalMu.RLock()
+	defer callJournalMu.RUnlock()
+	return callJournal
+}
+
+// journalEntry is a call recently made through Heimdall, with the whole request encoded as JSON and the JSON encoded
+// values of its key dimensions read from gRPC metadata. Include and exclude fields and KeyFunc are not applied to the
+// request, so that replaying it produces the same cache key.
+type journalEntry struct {
+	RPCCallName string        `json:"rpc_call_name"`
+	Request     string        `json:"request"`
+	Metadata    string        `json:"metadata,omitempty"`
+	SoftTTL     time.Duration `json:"soft_ttl"`
+	HardTTL     time.Duration `json:"hard_ttl"`
+}
+
+// recentCallJournal keeps the most recently seen calls under the current version and periodically writes them to the
+// cache, so that the next version can be warmed from them. The journal holds requests, so it is written like any other
+// entry: compressed, and encrypted and signed if a KeyProvider or IntegrityConfig is set.
+type recentCallJournal struct {
+	version string
+	size    int
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	entries map[journalEntry]*list.Element
+	lru     *list.List // most recently seen at the front
+	dirty   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newRecentCallJournal(version string, size int, ttl time.Duration) *recentCallJournal {
+	return &recentCallJournal{
+		version: version,
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[journalEntry]*list.Element),
+		lru:     list.New(),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (j *recentCallJournal) start(interval time.Duration) {
+	go func() {
+		defer close(j.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.flush()
+			case <-j.stop:
+				j.flush()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the journal after writing it to the cache one last time.
+func (j *recentCallJournal) Stop() {
+	select {
+	case <-j.stop:
+	default:
+		close(j.stop)
+	}
+	<-j.done
+}
+
+func (j *recentCallJournal) record(entry journalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if elem, ok := j.entries[entry]; ok {
+		j.lru.MoveToFront(elem)
+		return
+	}
+
+	if len(j.entries) >= j.size {
+		oldest := j.lru.Remove(j.lru.Back()).(journalEntry)
+		delete(j.entries, oldest)
+	}
+	j.entries[entry] = j.lru.PushFront(entry)
+	j.dirty = true
+}
+
+// flush writes the journal to the cache if it changed since the last flush. Each instance overwrites the journal with
+// its own recent calls.
+func (j *recentCallJournal) flush() {
+	j.mu.Lock()
+	if !j.dirty {
+		j.mu.Unlock()
+		return
+	}
+	entries := make([]journalEntry, 0, j.lru.Len())
+	for elem := j.lru.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(journalEntry))
+	}
+	j.dirty = false
+	j.mu.Unlock()
+
+	ctx, cancel := backgroundContext(context.Background(), writeTimeout)
+	defer cancel()
+
+	err := func() error {
+		data, err := json.ConfigStd.MarshalToString(entries)
+		if err != nil {
+			return errors.Wrap(err, "unable to marshal call journal")
+		}
+		key := journalKey(j.version)
+		val, err := encodeCacheValue(ctx, key, &CacheValue{UpdatedTS: time.Now().Unix(), Data: data}, "")
+		if err != nil {
+			return err
+		}
+		return cacheProvider.Set(ctx, key, val, j.ttl)
+	}()
+	if err != nil {
+		reportError(ctx, "", errors.Wrap(err, "unable to write call journal"))
+	}
+}
+
+// journalKey returns the key of the journal of the service under version. The journal requires a namespace, so that
+// services sharing a cache do not overwrite each other's journal.
+func journalKey(version string) string {
+	return journalKeyPrefix + namespace + ":" + version
+}
+
+// readJournal reads the calls journaled under version, most recently seen first.
+func readJournal(ctx context.Context, version string) ([]journalEntry, error) {
+	key := journalKey(version)
+	val, err := cacheProvider.Get(ctx, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read call journal of version %q", version)
+	}
+
+	cacheVal := &CacheValue{}
+	if err = decodeCacheValue(ctx, key, val, cacheVal, ""); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode call journal of version %q", version)
+	}
+
+	var entries []journalEntry
+	if err = json.ConfigStd.UnmarshalFromString(cacheVal.Data, &entries); err != nil {
+		return nil, errors.Wrapf(err, "unable to unmarshal call journal of version %q", version)
+	}
+	return entries, nil
+}
+
+// recordCall records a call in the journal, if it is enabled. Calls with key dimensions read with an Extract function
+// are not recorded, as their replay could not reproduce their key.
+func recordCall(ctx context.Context, req any, rpcCallName string, softTTL, hardTTL time.Duration) {
+	j := getCallJournal()
+	if j == nil {
+		return
+	}
+	cd := getCallDimensions(ctx)
+	if cd.extracted {
+		return
+	}
+
+	entry := journalEntry{RPCCallName: rpcCallName, SoftTTL: softTTL, HardTTL: hardTTL}
+	var err error
+	if entry.Request, err = json.ConfigStd.MarshalToString(req); err != nil {
+		return
+	}
+	if len(cd.metadata) > 0 {
+		if entry.Metadata, err = json.ConfigStd.MarshalToString(cd.metadata); err != nil {
+			return
+		}
+	}
+	j.record(entry)
+}
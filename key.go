@@ -0,0 +1,255 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+var (
+	keyConfig        *KeyConfig
+	methodKeyConfigs map[string]*KeyConfig
+
+	keyHasher      constants.KeyHasherType
+	namespace      string
+	methodHashTags map[string]bool
+)
+
+const namespacedKeyPrefix = "heimdall"
+
+// KeyFunc returns the key material of a request, which replaces the JSON encoded request in the cache key. The key
+// material is still hashed together with the rpc call name, TTLs and version.
+type KeyFunc func(ctx context.Context, req any) (string, error)
+
+// KeyDebugHook is called with the cache key of a call and the key material it was hashed from. The key material may
+// contain request data.
+type KeyDebugHook func(ctx context.Context, rpcCallName, key, keyMaterial string)
+
+// KeyConfig configures how the cache key of a request is generated. By default, the whole JSON encoded request is part
+// of the key, so fields such as request ids or timestamps make every key unique.
+type KeyConfig struct {
+	// KeyFunc generates the key material of a request. IncludeFields and ExcludeFields are ignored if it is set.
+	// This field is optional.
+	KeyFunc KeyFunc `json:"-" yaml:"-" xml:"-"`
+	// IncludeFields are the only request fields that are part of the key. Fields are dot separated paths of JSON field
+	// names, such as "user.id", which are the protobuf field names for generated protobuf messages.
+	// This field is optional.
+	IncludeFields []string `json:"include_fields,omitempty" yaml:"include_fields,omitempty" xml:"include_fields,omitempty"`
+	// ExcludeFields are request fields that are not part of the key, in the same format as IncludeFields.
+	// This field is optional.
+	ExcludeFields []string `json:"exclude_fields,omitempty" yaml:"exclude_fields,omitempty" xml:"exclude_fields,omitempty"`
+	// CanonicalProto generates the key material of protobuf requests from the protobuf JSON mapping re-encoded with
+	// sorted field names, instead of JSON marshalling of the generated struct, so that keys do not change with
+	// protoc-gen-go or protobuf runtime upgrades. Unknown fields are discarded and fields set to their default value
+	// are left out. IncludeFields and ExcludeFields are then protobuf field names or JSON names. Enabling it changes
+	// the keys of protobuf requests.
+	CanonicalProto bool `json:"canonical_proto,omitempty" yaml:"canonical_proto,omitempty" xml:"canonical_proto,omitempty"`
+	// Dimensions are values of the context, such as outgoing gRPC metadata, that are mixed into the key because
+	// responses vary by them. This field is optional.
+	Dimensions []KeyDimension `json:"dimensions,omitempty" yaml:"dimensions,omitempty" xml:"dimensions,omitempty"`
+	// DebugHook is called with the key material of every call before it is hashed. It is meant to find out why calls
+	// do not share keys; send the key material to a sink suitable for request data. This field is optional.
+	DebugHook KeyDebugHook `json:"-" yaml:"-" xml:"-"`
+}
+
+func (c *KeyConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, field := range append(append([]string(nil), c.IncludeFields...), c.ExcludeFields...) {
+		if field == "" {
+			return errors.Errorf("key config cannot have empty fields")
+		}
+	}
+	for i := range c.Dimensions {
+		if err := c.Dimensions[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InjectKeyConfig sets the default key configuration and the per-method overrides, keyed by rpc call name.
+func InjectKeyConfig(cfg *KeyConfig, methodCfgs map[string]*KeyConfig) {
+	keyConfig = cfg
+	methodKeyConfigs = methodCfgs
+}
+
+// InjectKeyFormat sets the hash function of cache keys and their namespace. With a namespace, keys are
+// heimdall:<namespace>:<version>:<rpc call name>:<hash>, so that they can be inspected and scanned by service and
+// method.
+func InjectKeyFormat(hasher constants.KeyHasherType, ns string) {
+	keyHasher = hasher
+	namespace = ns
+}
+
+// InjectMethodHashTags sets the methods whose keys are co-located with a hash tag, keyed by rpc call name.
+func InjectMethodHashTags(hashTags map[string]bool) {
+	methodHashTags = hashTags
+}
+
+// formatKey hashes unhashed key material into the cache key of rpcCallName under version.
+func formatKey(rpcCallName, version, unhashedKey string) string {
+	hash := helpers.HashKeyWith(keyHasher, unhashedKey)
+	if namespace == "" {
+		return hash
+	}
+	return namespacePrefix(version) + methodKeySegment(rpcCallName) + ":" + hash
+}
+
+// namespacePrefix returns the prefix of the namespaced keys under version.
+func namespacePrefix(version string) string {
+	return namespacedKeyPrefix + ":" + namespace + ":" + version + ":"
+}
+
+// methodKeySegment wraps rpcCallName in a Redis Cluster hash tag if its keys are co-located, so that they are all
+// stored in the same hash slot.
+func methodKeySegment(rpcCallName string) string {
+	if methodHashTags[rpcCallName] {
+		return "{" + rpcCallName + "}"
+	}
+	return rpcCallName
+}
+
+// ScanPattern returns the pattern matching the cache keys of rpcCallName under the current namespace and version, for
+// example for the MATCH option of the Redis SCAN command to inspect or flush them. An empty rpcCallName matches the
+// keys of every method. It returns an empty string if there is no namespace, as keys are then bare hashes.
+func ScanPattern(rpcCallName string) string {
+	if namespace == "" {
+		return ""
+	}
+	if rpcCallName == "" {
+		return escapeGlob(namespacePrefix(version)) + "*"
+	}
+	return escapeGlob(namespacePrefix(version)+methodKeySegment(rpcCallName)) + ":*"
+}
+
+// escapeGlob escapes the special characters of Redis glob-style patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func getKeyConfig(rpcCallName string) *KeyConfig {
+	if cfg, ok := methodKeyConfigs[rpcCallName]; ok {
+		return cfg
+	}
+	return keyConfig
+}
+
+// keyOption is a grpc.CallOption that changes how the cache key of a single call is generated. gRPC ignores it.
+type keyOption struct {
+	grpc.EmptyCallOption
+	apply func(cfg *KeyConfig)
+}
+
+// WithKeyFunc generates the cache key of the call from the key material returned by f.
+func WithKeyFunc(f KeyFunc) grpc.CallOption {
+	return keyOption{apply: func(cfg *KeyConfig) { cfg.KeyFunc = f }}
+}
+
+// WithKeyFields makes fields the only request fields that are part of the cache key of the call.
+func WithKeyFields(fields ...string) grpc.CallOption {
+	return keyOption{apply: func(cfg *KeyConfig) { cfg.IncludeFields = fields }}
+}
+
+// WithoutKeyFields leaves fields out of the cache key of the call.
+func WithoutKeyFields(fields ...string) grpc.CallOption {
+	return keyOption{apply: func(cfg *KeyConfig) { cfg.ExcludeFields = fields }}
+}
+
+// WithKeyDebug calls hook with the key material of the call before it is hashed.
+func WithKeyDebug(hook KeyDebugHook) grpc.CallOption {
+	return keyOption{apply: func(cfg *KeyConfig) { cfg.DebugHook = hook }}
+}
+
+// callKeyConfig returns the key configuration of the method, overridden by the key options of the call.
+func callKeyConfig(rpcCallName string, opts []grpc.CallOption) KeyConfig {
+	var cfg KeyConfig
+	if methodCfg := getKeyConfig(rpcCallName); methodCfg != nil {
+		cfg = *methodCfg
+	}
+	for _, opt := range opts {
+		if o, ok := opt.(keyOption); ok {
+			o.apply(&cfg)
+		}
+	}
+	return cfg
+}
+
+// newKeyGenerator returns a function generating the cache key of req under a version. It returns
+// errMissingKeyDimension if the call must bypass the cache.
+func newKeyGenerator(ctx context.Context, req any, rpcCallName string, softTTL, hardTTL time.Duration,
+	opts []grpc.CallOption) (func(version string) string, error) {
+	cfg := callKeyConfig(rpcCallName, opts)
+
+	var (
+		keyMaterial string
+		err         error
+	)
+	msg, isProto := req.(proto.Message)
+	if cfg.KeyFunc != nil {
+		keyMaterial, err = cfg.KeyFunc(ctx, req)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate key material")
+		}
+	} else if cfg.CanonicalProto && isProto {
+		if keyMaterial, err = helpers.MarshalProtoRequest(msg, cfg.IncludeFields, cfg.ExcludeFields); err != nil {
+			return nil, err
+		}
+	} else {
+		if keyMaterial, err = helpers.MarshalRequest(req); err != nil {
+			return nil, err
+		}
+		if keyMaterial, err = helpers.SelectFields(keyMaterial, cfg.IncludeFields, cfg.ExcludeFields); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(cfg.Dimensions) > 0 {
+		dimensions, err := dimensionKeyMaterial(ctx, cfg.Dimensions)
+		if err != nil {
+			return nil, err
+		}
+		keyMaterial += ":" + dimensions
+	}
+
+	return func(version string) string {
+		unhashedKey := helpers.UnhashedCacheKey(rpcCallName, keyMaterial, softTTL, hardTTL, version)
+		key := formatKey(rpcCallName, version, unhashedKey)
+		if cfg.DebugHook != nil {
+			cfg.DebugHook(ctx, rpcCallName, key, unhashedKey)
+		}
+		return key
+	}, nil
+}
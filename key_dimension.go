@@ -0,0 +1,152 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// errMissingKeyDimension is returned when a required key dimension is missing from the context. The call then
+// bypasses the cache, so that it never shares an entry with calls of another tenant, locale etc.
+var errMissingKeyDimension = errors.New("required key dimension is missing")
+
+// KeyDimension is a value of the context that responses vary by, such as a tenant id, a locale or an A/B bucket,
+// which is not part of the request. It is mixed into the cache key, so that calls with different values do not
+// share entries.
+type KeyDimension struct {
+	// Name identifies the dimension in the key material.
+	Name string `json:"name,omitempty" yaml:"name,omitempty" xml:"name,omitempty"`
+	// MetadataKey is the outgoing gRPC metadata key the dimension is read from. Either MetadataKey or Extract must be
+	// set. Dimensions read from metadata are carried over to refresh-ahead refreshes and to warm-up calls replayed
+	// from the call journal.
+	MetadataKey string `json:"metadata_key,omitempty" yaml:"metadata_key,omitempty" xml:"metadata_key,omitempty"`
+	// Extract reads the dimension from the context, and returns false if it is missing. Calls of methods with such
+	// dimensions are neither refreshed ahead nor journaled, as their context cannot be reproduced in the background.
+	Extract func(ctx context.Context) (string, bool) `json:"-" yaml:"-" xml:"-"`
+	// Required makes calls without the dimension bypass the cache instead of sharing an entry.
+	Required bool `json:"required,omitempty" yaml:"required,omitempty" xml:"required,omitempty"`
+}
+
+func (d *KeyDimension) validate() error {
+	if d.Name == "" {
+		return errors.Errorf("key dimension must have a name")
+	}
+	if (d.MetadataKey == "") == (d.Extract == nil) {
+		return errors.Errorf("key dimension %s must have either a metadata key or an extract function", d.Name)
+	}
+	return nil
+}
+
+// values returns the values of the dimension in ctx, and false if it is missing.
+func (d *KeyDimension) values(ctx context.Context) ([]string, bool) {
+	if d.Extract != nil {
+		v, ok := d.Extract(ctx)
+		return []string{v}, ok
+	}
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	values := md.Get(d.MetadataKey)
+	return values, len(values) > 0
+}
+
+// WithKeyDimensions mixes dimensions into the cache key of the call, replacing the dimensions of the method.
+func WithKeyDimensions(dimensions ...KeyDimension) grpc.CallOption {
+	return keyOption{apply: func(cfg *KeyConfig) { cfg.Dimensions = dimensions }}
+}
+
+// dimensionKeyMaterial returns the key material of the dimensions found in ctx, or errMissingKeyDimension if a
+// required dimension is missing. The values of each dimension are encoded as a JSON array, so that multiple metadata
+// values can never collide with a single value. Missing optional dimensions are left out.
+func dimensionKeyMaterial(ctx context.Context, dimensions []KeyDimension) (string, error) {
+	values := make(map[string][]string, len(dimensions))
+	for i := range dimensions {
+		d := &dimensions[i]
+		v, ok := d.values(ctx)
+		if !ok {
+			if d.Required {
+				return "", errors.Wrap(errMissingKeyDimension, d.Name)
+			}
+			continue
+		}
+		values[d.Name] = v
+	}
+
+	material, err := json.ConfigStd.MarshalToString(values) // map keys are sorted
+	if err != nil {
+		return "", errors.Wrap(err, "unable to marshal key dimensions")
+	}
+	return material, nil
+}
+
+type callDimensionsKey struct{}
+
+// callDimensions are the key dimensions of a call, kept in its context so that background work on its key can
+// reproduce them.
+type callDimensions struct {
+	// metadata holds the values of the dimensions read from outgoing gRPC metadata.
+	metadata metadata.MD
+	// extracted is set if the method has dimensions read with an Extract function, which cannot be reproduced.
+	extracted bool
+}
+
+// withCallDimensions records the key dimensions of the call in ctx, if its method has any.
+func withCallDimensions(ctx context.Context, rpcCallName string, opts []grpc.CallOption) context.Context {
+	dimensions := callKeyConfig(rpcCallName, opts).Dimensions
+	if len(dimensions) == 0 {
+		return ctx
+	}
+
+	cd := callDimensions{metadata: metadata.MD{}}
+	for i := range dimensions {
+		d := &dimensions[i]
+		if d.Extract != nil {
+			cd.extracted = true
+			continue
+		}
+		if values, ok := d.values(ctx); ok {
+			cd.metadata.Set(d.MetadataKey, values...)
+		}
+	}
+	return context.WithValue(ctx, callDimensionsKey{}, cd)
+}
+
+func getCallDimensions(ctx context.Context) callDimensions {
+	cd, _ := ctx.Value(callDimensionsKey{}).(callDimensions)
+	return cd
+}
+
+// copyCallDimensions carries the key dimensions of the call of src over to dst as outgoing metadata, without the rest of
+// the metadata of the call.
+func copyCallDimensions(dst, src context.Context) context.Context {
+	return appendOutgoingMetadata(dst, getCallDimensions(src).metadata)
+}
+
+func appendOutgoingMetadata(ctx context.Context, md metadata.MD) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	existing, _ := metadata.FromOutgoingContext(ctx)
+	return metadata.NewOutgoingContext(ctx, metadata.Join(existing, md))
+}
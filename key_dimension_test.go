@@ -0,0 +1,137 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type testLocaleKey struct{}
+
+func TestKeyDimensions(t *testing.T) {
+	tenant := KeyDimension{Name: "tenant", MetadataKey: "x-tenant-id", Required: true}
+	locale := KeyDimension{Name: "locale", Extract: func(ctx context.Context) (string, bool) {
+		v, ok := ctx.Value(testLocaleKey{}).(string)
+		return v, ok
+	}}
+	InjectKeyConfig(&KeyConfig{Dimensions: []KeyDimension{tenant, locale}}, nil)
+	t.Cleanup(func() { InjectKeyConfig(nil, nil) })
+
+	keyOf := func(ctx context.Context, opts ...grpc.CallOption) (string, error) {
+		keyFor, err := newKeyGenerator(ctx, testReq, "rpcCallName", time.Second, time.Minute, opts)
+		if err != nil {
+			return "", err
+		}
+		return keyFor("v1"), nil
+	}
+
+	ctx := context.Background()
+	first := metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "first")
+	second := metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "second")
+	firstKey, err := keyOf(first)
+	assert.NoError(t, err)
+	secondKey, err := keyOf(second)
+	assert.NoError(t, err)
+	assert.NotEqual(t, firstKey, secondKey)
+
+	again, err := keyOf(metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "first"))
+	assert.NoError(t, err)
+	assert.Equal(t, firstKey, again)
+
+	// multiple values never collide with a single value
+	joined, err := keyOf(metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "first,second"))
+	assert.NoError(t, err)
+	multiple, err := keyOf(metadata.AppendToOutgoingContext(ctx, "x-tenant-id", "first", "x-tenant-id", "second"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, joined, multiple)
+
+	localized, err := keyOf(context.WithValue(first, testLocaleKey{}, "en"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, firstKey, localized)
+
+	_, err = keyOf(ctx)
+	assert.True(t, errors.Is(err, errMissingKeyDimension))
+
+	withoutDimensions, err := keyOf(ctx, WithKeyDimensions())
+	assert.NoError(t, err)
+	assert.NotEqual(t, firstKey, withoutDimensions)
+}
+
+func TestKeyDimensionMissingBypassesCache(t *testing.T) {
+	assert.NoError(t, Init(testConfig))
+	data := map[string]any{}
+	mockCache(data)
+	InjectKeyConfig(&KeyConfig{Dimensions: []KeyDimension{{Name: "tenant", MetadataKey: "x-tenant-id", Required: true}}}, nil)
+	t.Cleanup(func() { InjectKeyConfig(nil, nil) })
+
+	calls := 0
+	grpcFunc := func(ctx context.Context, req *TestRPCRequest, opts ...grpc.CallOption) (*TestRPCResponse, error) {
+		calls++
+		return testResp, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := GRPCCall(grpcFunc, context.Background(), testReq)
+		assert.NoError(t, err)
+		assert.Equal(t, testResp, got)
+	}
+	waitForBackgroundTasks()
+	assert.Equal(t, 2, calls)
+	assert.Empty(t, data)
+}
+
+func TestKeyDimensionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		dimension KeyDimension
+		err       bool
+	}{
+		{
+			name:      "metadata",
+			dimension: KeyDimension{Name: "tenant", MetadataKey: "x-tenant-id"},
+			err:       false,
+		}, {
+			name:      "no name",
+			dimension: KeyDimension{MetadataKey: "x-tenant-id"},
+			err:       true,
+		}, {
+			name:      "no source",
+			dimension: KeyDimension{Name: "tenant"},
+			err:       true,
+		}, {
+			name: "both sources",
+			dimension: KeyDimension{Name: "tenant", MetadataKey: "x-tenant-id", Extract: func(ctx context.Context) (string, bool) {
+				return "", false
+			}},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &KeyConfig{Dimensions: []KeyDimension{tt.dimension}}
+			assert.Equal(t, tt.err, cfg.validate() != nil)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+type testKeyRequest struct {
+	UserID    string `json:"user_id"`
+	RequestID string `json:"request_id"`
+}
+
+func TestKeyConfig(t *testing.T) {
+	first := &testKeyRequest{UserID: "1", RequestID: "a"}
+	second := &testKeyRequest{UserID: "1", RequestID: "b"}
+	tests := []struct {
+		name       string
+		config     *KeyConfig
+		methodCfgs map[string]*KeyConfig
+		opts       []grpc.CallOption
+		sameKey    bool
+	}{
+		{
+			name:    "whole request",
+			sameKey: false,
+		}, {
+			name:    "excluded field",
+			config:  &KeyConfig{ExcludeFields: []string{"request_id"}},
+			sameKey: true,
+		}, {
+			name:    "included field",
+			config:  &KeyConfig{IncludeFields: []string{"user_id"}},
+			sameKey: true,
+		}, {
+			name:       "method override",
+			config:     &KeyConfig{ExcludeFields: []string{"request_id"}},
+			methodCfgs: map[string]*KeyConfig{"rpcCallName": nil},
+			sameKey:    false,
+		}, {
+			name: "key func",
+			config: &KeyConfig{KeyFunc: func(ctx context.Context, req any) (string, error) {
+				return req.(*testKeyRequest).UserID, nil
+			}},
+			sameKey: true,
+		}, {
+			name:    "call option",
+			opts:    []grpc.CallOption{WithoutKeyFields("request_id")},
+			sameKey: true,
+		}, {
+			name:    "call option overrides config",
+			config:  &KeyConfig{ExcludeFields: []string{"request_id"}},
+			opts:    []grpc.CallOption{WithoutKeyFields()},
+			sameKey: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InjectKeyConfig(tt.config, tt.methodCfgs)
+			t.Cleanup(func() { InjectKeyConfig(nil, nil) })
+
+			ctx := context.Background()
+			firstKeyFor, err := newKeyGenerator(ctx, first, "rpcCallName", time.Second, time.Minute, tt.opts)
+			assert.NoError(t, err)
+			secondKeyFor, err := newKeyGenerator(ctx, second, "rpcCallName", time.Second, time.Minute, tt.opts)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.sameKey, firstKeyFor("v1") == secondKeyFor("v1"))
+			assert.NotEqual(t, firstKeyFor("v1"), firstKeyFor("v2"))
+		})
+	}
+}
+
+func TestKeyConfigDefaultKey(t *testing.T) {
+	keyFor, err := newKeyGenerator(context.Background(), testReq, "rpcCallName", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	key, err := helpers.GenerateCacheKey(testReq, "rpcCallName", time.Second, time.Minute, "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, key, keyFor("v1"))
+}
+
+func TestGRPCCallPassesCallOptions(t *testing.T) {
+	assert.NoError(t, Init(testConfig))
+	mockCache(map[string]any{})
+
+	var got []grpc.CallOption
+	grpcFunc := func(ctx context.Context, req *TestRPCRequest, opts ...grpc.CallOption) (*TestRPCResponse, error) {
+		got = opts
+		return testResp, nil
+	}
+	opt := grpc.WaitForReady(true)
+	_, err := GRPCCall(grpcFunc, context.Background(), testReq, opt)
+	assert.NoError(t, err)
+	assert.Equal(t, []grpc.CallOption{opt}, got)
+	waitForBackgroundTasks()
+}
+
+func TestKeyDebugHook(t *testing.T) {
+	var debugged []string
+	hook := func(ctx context.Context, rpcCallName, key, keyMaterial string) {
+		debugged = append(debugged, rpcCallName, key, keyMaterial)
+	}
+	InjectKeyConfig(&KeyConfig{DebugHook: hook}, map[string]*KeyConfig{"otherCall": {}})
+	t.Cleanup(func() { InjectKeyConfig(nil, nil) })
+
+	ctx := context.Background()
+	keyFor, err := newKeyGenerator(ctx, testReq, "rpcCallName", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	key := keyFor("v1")
+	keyMaterial, err := helpers.MarshalRequest(testReq)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rpcCallName", key, helpers.UnhashedCacheKey("rpcCallName", keyMaterial, time.Second, time.Minute, "v1")}, debugged)
+
+	// methods without a hook are not debugged, unless the call sets one
+	debugged = nil
+	keyFor, err = newKeyGenerator(ctx, testReq, "otherCall", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	keyFor("v1")
+	assert.Empty(t, debugged)
+	keyFor, err = newKeyGenerator(ctx, testReq, "otherCall", time.Second, time.Minute, []grpc.CallOption{WithKeyDebug(hook)})
+	assert.NoError(t, err)
+	keyFor("v1")
+	assert.Len(t, debugged, 3)
+}
+
+func TestKeyConfigCanonicalProto(t *testing.T) {
+	InjectKeyConfig(&KeyConfig{CanonicalProto: true, ExcludeFields: []string{"number"}}, nil)
+	t.Cleanup(func() { InjectKeyConfig(nil, nil) })
+
+	ctx := context.Background()
+	first := &descriptorpb.FieldDescriptorProto{Name: proto.String("user_id"), Number: proto.Int32(1)}
+	second := &descriptorpb.FieldDescriptorProto{Name: proto.String("user_id"), Number: proto.Int32(2)}
+	firstKeyFor, err := newKeyGenerator(ctx, first, "rpcCallName", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	secondKeyFor, err := newKeyGenerator(ctx, second, "rpcCallName", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, firstKeyFor("v1"), secondKeyFor("v1"))
+
+	keyMaterial, err := helpers.MarshalProtoRequest(first, nil, []string{"number"})
+	assert.NoError(t, err)
+	assert.Equal(t, helpers.HashKey(helpers.UnhashedCacheKey("rpcCallName", keyMaterial, time.Second, time.Minute, "v1")), firstKeyFor("v1"))
+}
+
+func TestKeyFormat(t *testing.T) {
+	InjectKeyFormat(constants.XXH3KeyHasher, "")
+	t.Cleanup(func() { InjectKeyFormat(constants.SHA512KeyHasher, "") })
+
+	keyFor, err := newKeyGenerator(context.Background(), testReq, "rpcCallName", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	assert.Len(t, keyFor("v1"), 22)
+	assert.Equal(t, "", ScanPattern("rpcCallName"))
+}
+
+func TestKeyNamespace(t *testing.T) {
+	InjectVersion("v1")
+	InjectKeyFormat(constants.XXH3KeyHasher, "profile")
+	InjectMethodHashTags(map[string]bool{"tagged": true})
+	t.Cleanup(func() {
+		InjectVersion("")
+		InjectKeyFormat(constants.SHA512KeyHasher, "")
+		InjectMethodHashTags(nil)
+	})
+
+	ctx := context.Background()
+	keyFor, err := newKeyGenerator(ctx, testReq, "rpcCallName", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	key := keyFor("v1")
+	assert.True(t, strings.HasPrefix(key, "heimdall:profile:v1:rpcCallName:"))
+	assert.Len(t, key, len("heimdall:profile:v1:rpcCallName:")+22)
+	assert.True(t, strings.HasPrefix(keyFor("v0"), "heimdall:profile:v0:rpcCallName:"))
+
+	taggedKeyFor, err := newKeyGenerator(ctx, testReq, "tagged", time.Second, time.Minute, nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(taggedKeyFor("v1"), "heimdall:profile:v1:{tagged}:"))
+
+	assert.Equal(t, "heimdall:profile:v1:*", ScanPattern(""))
+	assert.Equal(t, "heimdall:profile:v1:rpcCallName:*", ScanPattern("rpcCallName"))
+	assert.Equal(t, "heimdall:profile:v1:{tagged}:*", ScanPattern("tagged"))
+	assert.Equal(t, `heimdall:profile:v1:F\[T\]:*`, ScanPattern("F[T]"))
+	assert.Equal(t, "heimdall-journal:profile:v1", journalKey("v1"))
+}
@@ -0,0 +1,200 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+// ErrDownstreamLimited is returned when a call to the downstream service is rejected by its concurrency or rate limiter.
+var ErrDownstreamLimited = errors.New("downstream call rejected by heimdall limiter")
+
+var (
+	downstreamLimitConfig        *DownstreamLimitConfig
+	methodDownstreamLimitConfigs map[string]*DownstreamLimitConfig
+	downstreamLimiters           sync.Map // rpc call name -> *downstreamLimiter
+)
+
+// DownstreamLimitConfig protects a downstream service from the calls Heimdall makes to it on cache misses and soft TTL
+// refreshes, for example during a cold start or after an invalidation.
+type DownstreamLimitConfig struct {
+	// MaxConcurrency is the maximum number of concurrent calls to the method. 0 means no concurrency limit.
+	MaxConcurrency int `json:"max_concurrency,omitempty" yaml:"max_concurrency,omitempty" xml:"max_concurrency,omitempty"`
+	// RateLimit is the maximum number of calls per second to the method. 0 means no rate limit.
+	RateLimit float64 `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty" xml:"rate_limit,omitempty"`
+	// Burst is the size of the token bucket. Defaults to RateLimit rounded up.
+	Burst int `json:"burst,omitempty" yaml:"burst,omitempty" xml:"burst,omitempty"`
+	// Policy is the behaviour when a call is over the limit. Defaults to WaitLimitPolicy.
+	Policy constants.LimitPolicyType `json:"policy,omitempty" yaml:"policy,omitempty" xml:"policy,omitempty"`
+	// WaitTimeout is the maximum time a call waits for the limiter under WaitLimitPolicy. 0 means the call waits
+	// until its context is done.
+	WaitTimeout time.Duration `json:"wait_timeout,omitempty" yaml:"wait_timeout,omitempty" xml:"wait_timeout,omitempty"`
+}
+
+func (c *DownstreamLimitConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxConcurrency < 0 || c.RateLimit < 0 || c.Burst < 0 || c.WaitTimeout < 0 {
+		return errors.Errorf("downstream limit config cannot have negative values")
+	}
+
+	if c.Policy < constants.WaitLimitPolicy || c.Policy > constants.FailFastLimitPolicy {
+		return errors.Errorf("invalid downstream limit policy specified.")
+	}
+	return nil
+}
+
+type downstreamLimiter struct {
+	sem         chan struct{}
+	bucket      *rate.Limiter
+	policy      constants.LimitPolicyType
+	waitTimeout time.Duration
+}
+
+func newDownstreamLimiter(cfg *DownstreamLimitConfig) *downstreamLimiter {
+	l := &downstreamLimiter{
+		policy:      cfg.Policy,
+		waitTimeout: cfg.WaitTimeout,
+	}
+	if cfg.MaxConcurrency > 0 {
+		l.sem = make(chan struct{}, cfg.MaxConcurrency)
+	}
+	if cfg.RateLimit > 0 {
+		burst := cfg.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(cfg.RateLimit))
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+	return l
+}
+
+// acquire admits a call to the downstream service according to the limiter's policy. The returned release function
+// must be called once the call is done.
+func (l *downstreamLimiter) acquire(ctx context.Context) (func(), error) {
+	if l.policy != constants.WaitLimitPolicy {
+		return l.tryAcquire()
+	}
+
+	if l.waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.waitTimeout)
+		defer cancel()
+	}
+
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, errors.Wrap(ErrDownstreamLimited, ctx.Err().Error())
+		}
+	}
+
+	if l.bucket != nil {
+		if err := l.bucket.Wait(ctx); err != nil {
+			l.release()
+			return nil, errors.Wrap(ErrDownstreamLimited, err.Error())
+		}
+	}
+	return l.release, nil
+}
+
+func (l *downstreamLimiter) tryAcquire() (func(), error) {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			return nil, errors.Wrap(ErrDownstreamLimited, "max concurrency reached")
+		}
+	}
+
+	if l.bucket != nil && !l.bucket.Allow() {
+		l.release()
+		return nil, errors.Wrap(ErrDownstreamLimited, "rate limit reached")
+	}
+	return l.release, nil
+}
+
+func (l *downstreamLimiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// InjectDownstreamLimitConfig sets the default downstream limits and the per-method overrides, keyed by rpc call name.
+func InjectDownstreamLimitConfig(cfg *DownstreamLimitConfig, methodCfgs map[string]*DownstreamLimitConfig) {
+	downstreamLimitConfig = cfg
+	methodDownstreamLimitConfigs = methodCfgs
+	downstreamLimiters.Range(func(key, _ any) bool {
+		downstreamLimiters.Delete(key)
+		return true
+	})
+}
+
+func getDownstreamLimiter(rpcCallName string) *downstreamLimiter {
+	if l, ok := downstreamLimiters.Load(rpcCallName); ok {
+		return l.(*downstreamLimiter)
+	}
+
+	cfg, ok := methodDownstreamLimitConfigs[rpcCallName]
+	if !ok {
+		cfg = downstreamLimitConfig
+	}
+	if cfg == nil {
+		return nil
+	}
+
+	l, _ := downstreamLimiters.LoadOrStore(rpcCallName, newDownstreamLimiter(cfg))
+	return l.(*downstreamLimiter)
+}
+
+// downstreamLimitPolicy returns the limit policy for rpcCallName, and false if the method is not limited.
+func downstreamLimitPolicy(rpcCallName string) (constants.LimitPolicyType, bool) {
+	l := getDownstreamLimiter(rpcCallName)
+	if l == nil {
+		return constants.WaitLimitPolicy, false
+	}
+	return l.policy, true
+}
+
+// acquireDownstream admits a call to the downstream service of rpcCallName. If there is no limiter configured for the
+// method, the call is always admitted. The returned release function must be called once the call is done.
+func acquireDownstream(ctx context.Context, rpcCallName string) (func(), error) {
+	l := getDownstreamLimiter(rpcCallName)
+	if l == nil {
+		return func() {}, nil
+	}
+
+	release, err := l.acquire(ctx)
+	if err != nil {
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseDownstreamLimitedMetric(ctx, rpcCallName)
+		}
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/cache"
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestDownstreamLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *DownstreamLimitConfig
+		admitted []bool
+	}{
+		{
+			name:     "concurrency fail fast",
+			config:   &DownstreamLimitConfig{MaxConcurrency: 2, Policy: constants.FailFastLimitPolicy},
+			admitted: []bool{true, true, false},
+		}, {
+			name:     "rate limit fail fast",
+			config:   &DownstreamLimitConfig{RateLimit: 1, Burst: 1, Policy: constants.FailFastLimitPolicy},
+			admitted: []bool{true, false, false},
+		}, {
+			name:     "concurrency wait with timeout",
+			config:   &DownstreamLimitConfig{MaxConcurrency: 1, Policy: constants.WaitLimitPolicy, WaitTimeout: 10 * time.Millisecond},
+			admitted: []bool{true, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newDownstreamLimiter(tt.config)
+			for _, admitted := range tt.admitted {
+				_, err := l.acquire(context.Background())
+				assert.Equal(t, admitted, err == nil)
+				if !admitted {
+					assert.ErrorIs(t, err, ErrDownstreamLimited)
+				}
+			}
+		})
+	}
+}
+
+func TestDownstreamLimitOnCacheMiss(t *testing.T) {
+	InjectDownstreamLimitConfig(nil, map[string]*DownstreamLimitConfig{
+		"limitedCall": {MaxConcurrency: 1, Policy: constants.FailFastLimitPolicy},
+	})
+	defer InjectDownstreamLimitConfig(nil, nil)
+
+	release, err := acquireDownstream(context.Background(), "limitedCall")
+	assert.NoError(t, err)
+
+	rpcCall := func(ctx context.Context) (*string, error) {
+		resp := "response"
+		return &resp, nil
+	}
+	_, err = handleCacheMiss(context.Background(), "cacheKey", rpcCall, time.Second, 2*time.Second, "limitedCall", func(*string) bool { return false })
+	assert.ErrorIs(t, err, ErrDownstreamLimited)
+
+	release()
+	_, err = handleCacheMiss(context.Background(), "cacheKey", rpcCall, time.Second, 2*time.Second, "limitedCall", func(*string) bool { return false })
+	assert.NoError(t, err)
+
+	// methods without a limiter are never limited
+	_, err = handleCacheMiss(context.Background(), "cacheKey", rpcCall, time.Second, 2*time.Second, "otherCall", func(*string) bool { return false })
+	assert.NoError(t, err)
+}
+
+func TestDownstreamLimitOnSoftHit(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   constants.LimitPolicyType
+		reported bool
+	}{
+		{
+			name:     "stale",
+			policy:   constants.StaleLimitPolicy,
+			reported: false,
+		}, {
+			name:     "fail fast",
+			policy:   constants.FailFastLimitPolicy,
+			reported: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waitForBackgroundTasks()
+			client := &MockedCache{}
+			cacheProvider = &cache.Client{GetAPI: client, SetAPI: client}
+			InjectDownstreamLimitConfig(&DownstreamLimitConfig{MaxConcurrency: 1, Policy: tt.policy}, nil)
+			defer InjectDownstreamLimitConfig(nil, nil)
+			var reported error
+			InjectErrorHook(func(ctx context.Context, rpcCallName string, err error) { reported = err })
+			defer InjectErrorHook(nil)
+
+			release, err := acquireDownstream(context.Background(), "rpcCallName")
+			assert.NoError(t, err)
+			defer release()
+
+			rpcCall := func(ctx context.Context) (*map[string]string, error) {
+				return &map[string]string{"response": "response"}, nil
+			}
+			res, err := getData(context.Background(), rpcCall, "rpcCallName", "cacheKey", nil, time.Second, 2*time.Second,
+				func() bool { return true }, func(*map[string]string) bool { return true })
+			// the stale entry is served and the refresh is skipped
+			assert.NoError(t, err)
+			assert.NotNil(t, res)
+
+			waitForBackgroundTasks()
+			assert.Equal(t, 0, client.NumSet)
+			assert.Equal(t, tt.reported, errors.Is(reported, ErrDownstreamLimited))
+		})
+	}
+}
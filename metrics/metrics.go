@@ -18,6 +18,10 @@ package metrics
 
 import (
 	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Client is a metrics client.
@@ -25,6 +29,18 @@ type Client struct {
 	IncreaseMetricAPI IIncreaseMetric
 }
 
+// Close closes the underlying metrics client if it implements io.Closer, flushing any buffered metrics.
+func (c *Client) Close() error {
+	closer, ok := c.IncreaseMetricAPI.(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := closer.Close(); err != nil {
+		return errors.Wrap(err, "unable to close metrics client")
+	}
+	return nil
+}
+
 // IIncreaseMetric is an interface for a basic increase metrics client.
 type IIncreaseMetric interface {
 	IncreaseCacheHitMetric(ctx context.Context, metricName string)
@@ -46,3 +62,272 @@ func (c *Client) IncreaseCacheMissMetric(ctx context.Context, metricName string)
 func (c *Client) IncreaseCacheSoftHitMetric(ctx context.Context, metricName string) {
 	c.IncreaseMetricAPI.IncreaseCacheSoftHitMetric(ctx, metricName)
 }
+
+// IWorkerPoolMetric is an optional interface for metrics clients that wish to track Heimdall's background worker pool.
+// If the client passed in does not implement it, the worker pool metrics are simply not emitted.
+type IWorkerPoolMetric interface {
+	EmitWorkerPoolQueueDepthMetric(ctx context.Context, depth int)
+	IncreaseWorkerPoolDroppedTaskMetric(ctx context.Context, metricName string)
+}
+
+// EmitWorkerPoolQueueDepthMetric emits the number of background tasks waiting in the worker pool queue.
+func (c *Client) EmitWorkerPoolQueueDepthMetric(ctx context.Context, depth int) {
+	if m, ok := c.IncreaseMetricAPI.(IWorkerPoolMetric); ok {
+		m.EmitWorkerPoolQueueDepthMetric(ctx, depth)
+	}
+}
+
+// IncreaseWorkerPoolDroppedTaskMetric increases the dropped background task metric.
+func (c *Client) IncreaseWorkerPoolDroppedTaskMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IWorkerPoolMetric); ok {
+		m.IncreaseWorkerPoolDroppedTaskMetric(ctx, metricName)
+	}
+}
+
+// IBackgroundErrorMetric is an optional interface for metrics clients that wish to track failures of background work.
+type IBackgroundErrorMetric interface {
+	IncreaseCacheWriteErrorMetric(ctx context.Context, metricName string)
+	IncreaseRefreshErrorMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseCacheWriteErrorMetric increases the failed background cache write metric.
+func (c *Client) IncreaseCacheWriteErrorMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IBackgroundErrorMetric); ok {
+		m.IncreaseCacheWriteErrorMetric(ctx, metricName)
+	}
+}
+
+// IncreaseRefreshErrorMetric increases the failed soft TTL refresh metric.
+func (c *Client) IncreaseRefreshErrorMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IBackgroundErrorMetric); ok {
+		m.IncreaseRefreshErrorMetric(ctx, metricName)
+	}
+}
+
+// ICircuitBreakerMetric is an optional interface for metrics clients that wish to track the cache circuit breaker.
+type ICircuitBreakerMetric interface {
+	EmitCacheCircuitStateChangeMetric(ctx context.Context, from, to string)
+	IncreaseCacheWriteSkippedMetric(ctx context.Context, metricName string)
+}
+
+// EmitCacheCircuitStateChangeMetric emits a state change of the cache circuit breaker.
+func (c *Client) EmitCacheCircuitStateChangeMetric(ctx context.Context, from, to string) {
+	if m, ok := c.IncreaseMetricAPI.(ICircuitBreakerMetric); ok {
+		m.EmitCacheCircuitStateChangeMetric(ctx, from, to)
+	}
+}
+
+// IncreaseCacheWriteSkippedMetric increases the metric of cache writes skipped while the circuit breaker is open.
+func (c *Client) IncreaseCacheWriteSkippedMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(ICircuitBreakerMetric); ok {
+		m.IncreaseCacheWriteSkippedMetric(ctx, metricName)
+	}
+}
+
+// IDownstreamLimitMetric is an optional interface for metrics clients that wish to track calls rejected by the
+// downstream concurrency and rate limiters.
+type IDownstreamLimitMetric interface {
+	IncreaseDownstreamLimitedMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseDownstreamLimitedMetric increases the metric of downstream calls rejected by the limiters.
+func (c *Client) IncreaseDownstreamLimitedMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IDownstreamLimitMetric); ok {
+		m.IncreaseDownstreamLimitedMetric(ctx, metricName)
+	}
+}
+
+// IHedgeMetric is an optional interface for metrics clients that wish to track hedged downstream calls.
+type IHedgeMetric interface {
+	IncreaseHedgeMetric(ctx context.Context, metricName string)
+	IncreaseHedgeWinMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseHedgeMetric increases the metric of hedge calls issued.
+func (c *Client) IncreaseHedgeMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IHedgeMetric); ok {
+		m.IncreaseHedgeMetric(ctx, metricName)
+	}
+}
+
+// IncreaseHedgeWinMetric increases the metric of hedge calls that returned before the original call.
+func (c *Client) IncreaseHedgeWinMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IHedgeMetric); ok {
+		m.IncreaseHedgeWinMetric(ctx, metricName)
+	}
+}
+
+// IRefreshAttemptMetric is an optional interface for metrics clients that wish to track every attempt of a background
+// soft TTL refresh, including retries.
+type IRefreshAttemptMetric interface {
+	IncreaseRefreshAttemptMetric(ctx context.Context, metricName string, attempt int, outcome string)
+}
+
+// IncreaseRefreshAttemptMetric increases the refresh attempt metric. Outcome is one of "success", "failure" or
+// "budget_exhausted".
+func (c *Client) IncreaseRefreshAttemptMetric(ctx context.Context, metricName string, attempt int, outcome string) {
+	if m, ok := c.IncreaseMetricAPI.(IRefreshAttemptMetric); ok {
+		m.IncreaseRefreshAttemptMetric(ctx, metricName, attempt, outcome)
+	}
+}
+
+// IRefreshAheadMetric is an optional interface for metrics clients that wish to track the refresh-ahead scheduler.
+type IRefreshAheadMetric interface {
+	EmitRefreshAheadTrackedKeysMetric(ctx context.Context, count int)
+	IncreaseRefreshAheadMetric(ctx context.Context, metricName string)
+	IncreaseRefreshAheadBudgetExhaustedMetric(ctx context.Context, metricName string)
+}
+
+// EmitRefreshAheadTrackedKeysMetric emits the number of keys tracked by the refresh-ahead scheduler.
+func (c *Client) EmitRefreshAheadTrackedKeysMetric(ctx context.Context, count int) {
+	if m, ok := c.IncreaseMetricAPI.(IRefreshAheadMetric); ok {
+		m.EmitRefreshAheadTrackedKeysMetric(ctx, count)
+	}
+}
+
+// IncreaseRefreshAheadMetric increases the metric of refreshes scheduled ahead of the hard TTL.
+func (c *Client) IncreaseRefreshAheadMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IRefreshAheadMetric); ok {
+		m.IncreaseRefreshAheadMetric(ctx, metricName)
+	}
+}
+
+// IncreaseRefreshAheadBudgetExhaustedMetric increases the metric of refreshes skipped as the refresh budget was spent.
+func (c *Client) IncreaseRefreshAheadBudgetExhaustedMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IRefreshAheadMetric); ok {
+		m.IncreaseRefreshAheadBudgetExhaustedMetric(ctx, metricName)
+	}
+}
+
+// IWarmMetric is an optional interface for metrics clients that wish to track cache warm-up calls.
+type IWarmMetric interface {
+	IncreaseWarmMetric(ctx context.Context, metricName string, outcome string)
+}
+
+// IncreaseWarmMetric increases the metric of cache warm-up calls, labelled with their outcome.
+func (c *Client) IncreaseWarmMetric(ctx context.Context, metricName string, outcome string) {
+	if m, ok := c.IncreaseMetricAPI.(IWarmMetric); ok {
+		m.IncreaseWarmMetric(ctx, metricName, outcome)
+	}
+}
+
+// IPreviousVersionMetric is an optional interface for metrics clients that wish to track entries served from a
+// previous cache version.
+type IPreviousVersionMetric interface {
+	IncreasePreviousVersionHitMetric(ctx context.Context, metricName string)
+}
+
+// IncreasePreviousVersionHitMetric increases the metric of misses served from an entry under a previous cache version.
+func (c *Client) IncreasePreviousVersionHitMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IPreviousVersionMetric); ok {
+		m.IncreasePreviousVersionHitMetric(ctx, metricName)
+	}
+}
+
+// IHotKeyMetric is an optional interface for metrics clients that wish to track hot keys served from the local cache.
+type IHotKeyMetric interface {
+	IncreaseHotKeyDetectedMetric(ctx context.Context, metricName, key string)
+	IncreaseHotKeyLocalHitMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseHotKeyDetectedMetric increases the metric of keys detected as hot and pinned in the local cache.
+func (c *Client) IncreaseHotKeyDetectedMetric(ctx context.Context, metricName, key string) {
+	if m, ok := c.IncreaseMetricAPI.(IHotKeyMetric); ok {
+		m.IncreaseHotKeyDetectedMetric(ctx, metricName, key)
+	}
+}
+
+// IncreaseHotKeyLocalHitMetric increases the metric of reads of hot keys served from the local cache. It is not
+// labelled with the key, to keep the cardinality of the metric bounded.
+func (c *Client) IncreaseHotKeyLocalHitMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IHotKeyMetric); ok {
+		m.IncreaseHotKeyLocalHitMetric(ctx, metricName)
+	}
+}
+
+// IAdmissionMetric is an optional interface for metrics clients that wish to track cache writes skipped by the
+// admission filter.
+type IAdmissionMetric interface {
+	IncreaseAdmissionSkipMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseAdmissionSkipMetric increases the metric of cache writes skipped as the key was not missed often enough.
+func (c *Client) IncreaseAdmissionSkipMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IAdmissionMetric); ok {
+		m.IncreaseAdmissionSkipMetric(ctx, metricName)
+	}
+}
+
+// IOversizeEntryMetric is an optional interface for metrics clients that wish to track entries larger than the
+// maximum entry size.
+type IOversizeEntryMetric interface {
+	EmitOversizeEntryMetric(ctx context.Context, metricName string, size int)
+}
+
+// EmitOversizeEntryMetric emits the size in bytes of an entry larger than the maximum entry size.
+func (c *Client) EmitOversizeEntryMetric(ctx context.Context, metricName string, size int) {
+	if m, ok := c.IncreaseMetricAPI.(IOversizeEntryMetric); ok {
+		m.EmitOversizeEntryMetric(ctx, metricName, size)
+	}
+}
+
+// ICompressionMetric is an optional interface for metrics clients that wish to track the compression of entries.
+type ICompressionMetric interface {
+	EmitCompressionMetric(ctx context.Context, metricName string, library string, ratio float64, duration time.Duration)
+	EmitDecompressionMetric(ctx context.Context, metricName string, library string, duration time.Duration)
+}
+
+// EmitCompressionMetric emits the compressed to uncompressed size ratio of an entry and the time spent compressing it.
+func (c *Client) EmitCompressionMetric(ctx context.Context, metricName string, library string, ratio float64, duration time.Duration) {
+	if m, ok := c.IncreaseMetricAPI.(ICompressionMetric); ok {
+		m.EmitCompressionMetric(ctx, metricName, library, ratio, duration)
+	}
+}
+
+// EmitDecompressionMetric emits the time spent decompressing an entry.
+func (c *Client) EmitDecompressionMetric(ctx context.Context, metricName string, library string, duration time.Duration) {
+	if m, ok := c.IncreaseMetricAPI.(ICompressionMetric); ok {
+		m.EmitDecompressionMetric(ctx, metricName, library, duration)
+	}
+}
+
+// IEncryptionMetric is an optional interface for metrics clients that wish to track encrypted entries that could not be
+// decrypted.
+type IEncryptionMetric interface {
+	IncreaseDecryptionFailureMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseDecryptionFailureMetric increases the metric of entries that could not be decrypted, or were rejected for not
+// being encrypted, and were treated as misses.
+func (c *Client) IncreaseDecryptionFailureMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IEncryptionMetric); ok {
+		m.IncreaseDecryptionFailureMetric(ctx, metricName)
+	}
+}
+
+// IIntegrityMetric is an optional interface for metrics clients that wish to track entries that failed their checksum
+// or signature verification.
+type IIntegrityMetric interface {
+	IncreaseIntegrityFailureMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseIntegrityFailureMetric increases the metric of entries that failed verification and were treated as misses.
+func (c *Client) IncreaseIntegrityFailureMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IIntegrityMetric); ok {
+		m.IncreaseIntegrityFailureMetric(ctx, metricName)
+	}
+}
+
+// IKeyDimensionMetric is an optional interface for metrics clients that wish to track calls that bypassed the cache
+// because a required key dimension was missing from their context.
+type IKeyDimensionMetric interface {
+	IncreaseMissingKeyDimensionMetric(ctx context.Context, metricName string)
+}
+
+// IncreaseMissingKeyDimensionMetric increases the metric of calls that bypassed the cache because of a missing key
+// dimension.
+func (c *Client) IncreaseMissingKeyDimensionMetric(ctx context.Context, metricName string) {
+	if m, ok := c.IncreaseMetricAPI.(IKeyDimensionMetric); ok {
+		m.IncreaseMissingKeyDimensionMetric(ctx, metricName)
+	}
+}
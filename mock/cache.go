@@ -66,7 +66,8 @@ type mockedCache struct {
 func (m *mockedCache) Get(_ context.Context, key string) ([]byte, error) {
 	mockedVal, ok := m.mockedData[key]
 	if !ok {
-		return nil, errors.Errorf("cannot find key in mocked cache: %s, cache: %s", key, helpers.DumpJSON(m.mockedData))
+		return nil, errors.Wrapf(cache.ErrNotFound, "cannot find key in mocked cache: %s, cache: %s", key,
+			helpers.DumpJSON(m.mockedData))
 	}
 
 	return mockedVal.([]byte), nil
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+)
+
+var (
+	previousVersions        []string
+	rewritePreviousVersions bool
+
+	// strictJSONAPI checks that an entry written under a previous version still fits the response type.
+	strictJSONAPI = json.Config{UseNumber: true, DisallowUnknownFields: true}.Froze()
+
+	errNoPreviousVersionHit = errors.New("no usable entry under the previous versions")
+)
+
+// InjectPreviousVersions sets the versions checked, in order, on a miss under the current version. If rewrite is set,
+// an entry found under a previous version is written under the current version as well.
+func InjectPreviousVersions(versions []string, rewrite bool) {
+	previousVersions = versions
+	rewritePreviousVersions = rewrite
+}
+
+// previousVersionKeys returns a function generating the cache keys of a request under the previous versions with
+// keyFor, or nil if there are no previous versions. The keys are only generated on a miss.
+func previousVersionKeys(keyFor func(version string) string) func() []string {
+	versions := previousVersions
+	if len(versions) == 0 {
+		return nil
+	}
+
+	return func() []string {
+		keys := make([]string, 0, len(versions))
+		for _, v := range versions {
+			keys = append(keys, keyFor(v))
+		}
+		return keys
+	}
+}
+
+// fetchFromPreviousVersions looks key up under the previous versions. An entry is only served if its payload still
+// decodes into the response type without unknown fields.
+func fetchFromPreviousVersions[response any](ctx context.Context, key string, previousKeys func() []string,
+	hardTTL time.Duration, rpcCallName string) (*CacheValue, error) {
+	if previousKeys == nil {
+		return nil, errNoPreviousVersionHit
+	}
+
+	for _, previousKey := range previousKeys() {
+		cacheVal, err := fetchFromCache(ctx, previousKey, rpcCallName)
+		if err != nil {
+			continue
+		}
+		if err = strictJSONAPI.UnmarshalFromString(cacheVal.Data, new(response)); err != nil {
+			continue
+		}
+
+		if !isSkipMetrics() {
+			metricsProvider.IncreasePreviousVersionHitMetric(ctx, rpcCallName)
+		}
+		if rewritePreviousVersions {
+			bgCtx := detachContext(ctx)
+			submitBackgroundTask(bgCtx, rpcCallName, func() {
+				rewriteCacheValue(bgCtx, key, cacheVal, hardTTL, rpcCallName)
+			})
+		}
+		return cacheVal, nil
+	}
+	return nil, errNoPreviousVersionHit
+}
+
+// rewriteCacheValue writes an entry found under a previous version under key. The entry keeps its age, so it expires
+// when it would have expired under the previous version.
+func rewriteCacheValue(ctx context.Context, key string, cacheVal *CacheValue, hardTTL time.Duration, rpcCallName string) {
+	ttl := hardTTL - time.Since(time.Unix(cacheVal.UpdatedTS, 0))
+	if ttl <= 0 {
+		return
+	}
+
+	writeCtx, cancel := backgroundContext(ctx, writeTimeout)
+	defer cancel()
+
+	if err := setCacheValue(writeCtx, key, cacheVal, ttl, rpcCallName); err != nil {
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseCacheWriteErrorMetric(ctx, rpcCallName)
+		}
+		reportError(ctx, rpcCallName, errors.Wrap(err, "unable to rewrite previous version entry"))
+	}
+}
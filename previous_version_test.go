@@ -0,0 +1,90 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+func TestPreviousVersionFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		cached   any
+		rewrite  bool
+		rpcCalls int32
+		resp     *TestRPCResponse
+	}{
+		{
+			name:     "compatible entry is served",
+			cached:   &TestRPCResponse{UserName: "Jane Doe"},
+			rpcCalls: 0,
+			resp:     &TestRPCResponse{UserName: "Jane Doe"},
+		}, {
+			name:     "compatible entry is rewritten",
+			cached:   &TestRPCResponse{UserName: "Jane Doe"},
+			rewrite:  true,
+			rpcCalls: 0,
+			resp:     &TestRPCResponse{UserName: "Jane Doe"},
+		}, {
+			name:     "incompatible entry is a miss",
+			cached:   map[string]string{"FullName": "Jane Doe"},
+			rpcCalls: 1,
+			resp:     testResp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waitForBackgroundTasks()
+			var rpcCalls int32
+			grpcFunc := func(ctx context.Context, req *TestRPCRequest, opts ...grpc.CallOption) (*TestRPCResponse, error) {
+				atomic.AddInt32(&rpcCalls, 1)
+				return testResp, nil
+			}
+			rpcCallName := helpers.GetFunctionName(grpcFunc)
+
+			previousKey, _ := helpers.GenerateCacheKey(testReq, rpcCallName, defaultSoftTTL, defaultHardTTL, "v1")
+			mockCache(map[string]any{previousKey: testMakeCacheValue(tt.cached, false)})
+			InjectCompressionLibrary(constants.GzipCompressionType)
+			InjectVersion("v2")
+			InjectPreviousVersions([]string{"v0", "v1"}, tt.rewrite)
+			t.Cleanup(func() {
+				InjectCompressionLibrary(constants.NoCompressionType)
+				InjectVersion("")
+				InjectPreviousVersions(nil, false)
+			})
+
+			got, err := GRPCCall(grpcFunc, context.Background(), testReq)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.resp, got)
+			assert.Equal(t, tt.rpcCalls, atomic.LoadInt32(&rpcCalls))
+
+			waitForBackgroundTasks()
+			currentKey, _ := helpers.GenerateCacheKey(testReq, rpcCallName, defaultSoftTTL, defaultHardTTL, "v2")
+			_, err = cacheProvider.Get(context.Background(), currentKey)
+			assert.Equal(t, tt.rewrite || tt.rpcCalls > 0, err == nil)
+		})
+	}
+}
@@ -0,0 +1,377 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultRefreshAheadMaxTrackedKeys        = 10000
+	defaultRefreshAheadMinAccessRate         = 0.1
+	defaultRefreshAheadInterval              = time.Second
+	defaultRefreshAheadMaxRefreshesPerSecond = 10
+	defaultRefreshAheadLeadDivisor           = 10
+)
+
+var (
+	// refreshAheadMu guards refreshAhead, which calls read while Shutdown or Init replace it.
+	refreshAheadMu sync.RWMutex
+	refreshAhead   *refreshAheadScheduler
+)
+
+// errRefreshNotWritten is returned by a refresh whose response was not written to the cache, so that the key is not
+// considered fresh.
+var errRefreshNotWritten = errors.New("refreshed response was not written to the cache")
+
+// RefreshAheadConfig enables the refresh-ahead scheduler. Soft TTL refreshes only happen when a request arrives in the
+// soft TTL window, so keys that are important but not requested in that window simply expire. The scheduler tracks
+// recently accessed keys and refreshes the ones that are accessed often enough before their hard TTL is reached.
+// Zero values fall back to the defaults.
+type RefreshAheadConfig struct {
+	// MaxTrackedKeys is the maximum number of keys tracked. The least recently accessed key is evicted when it is full.
+	// Defaults to 10000.
+	MaxTrackedKeys int `json:"max_tracked_keys,omitempty" yaml:"max_tracked_keys,omitempty" xml:"max_tracked_keys,omitempty"`
+	// MinAccessRate is the access rate, in accesses per second, a key must exceed to be refreshed ahead. Defaults to 0.1.
+	MinAccessRate float64 `json:"min_access_rate,omitempty" yaml:"min_access_rate,omitempty" xml:"min_access_rate,omitempty"`
+	// Lead is how long before the hard TTL a key is refreshed. Defaults to a tenth of the key's hard TTL.
+	Lead time.Duration `json:"lead,omitempty" yaml:"lead,omitempty" xml:"lead,omitempty"`
+	// Interval is how often tracked keys are checked. Defaults to 1 second.
+	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty" xml:"interval,omitempty"`
+	// MaxRefreshesPerSecond is the global budget of refreshes the scheduler may issue. Defaults to 10.
+	MaxRefreshesPerSecond float64 `json:"max_refreshes_per_second,omitempty" yaml:"max_refreshes_per_second,omitempty" xml:"max_refreshes_per_second,omitempty"`
+}
+
+func (c *RefreshAheadConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxTrackedKeys < 0 || c.MinAccessRate < 0 || c.Lead < 0 || c.Interval < 0 || c.MaxRefreshesPerSecond < 0 {
+		return errors.Errorf("refresh ahead config cannot have negative values")
+	}
+	return nil
+}
+
+type trackedKey struct {
+	// hits and updatedAt, in Unix nanoseconds, are updated atomically by touch without holding the scheduler lock.
+	// They come first to stay 64-bit aligned on 32-bit platforms.
+	hits      int64
+	updatedAt int64
+
+	key         string
+	rpcCallName string
+	hardTTL     time.Duration
+	refresh     func(done func(error))
+
+	// the fields below are guarded by the scheduler lock.
+	scannedHits int64 // hits at the previous scan
+	orderedHits int64 // hits when the key was last moved in the LRU list
+	rate        float64
+	refreshing  bool
+	elem        *list.Element
+}
+
+func (k *trackedKey) touch(updatedAt time.Time) {
+	atomic.AddInt64(&k.hits, 1)
+	k.advance(updatedAt)
+}
+
+// advance moves the update time of the key forward to updatedAt, unless it is already later.
+func (k *trackedKey) advance(updatedAt time.Time) {
+	ts := updatedAt.UnixNano()
+	for {
+		cur := atomic.LoadInt64(&k.updatedAt)
+		if ts <= cur || atomic.CompareAndSwapInt64(&k.updatedAt, cur, ts) {
+			return
+		}
+	}
+}
+
+func (k *trackedKey) lastUpdate() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&k.updatedAt))
+}
+
+// refreshAheadScheduler tracks recently accessed keys. Accesses of tracked keys, which happen on every cache hit, only
+// look the key up and update it atomically; the LRU list is reordered by scan, and by track before it evicts a key.
+type refreshAheadScheduler struct {
+	maxTrackedKeys int
+	minAccessRate  float64
+	lead           time.Duration
+	budget         *rate.Limiter
+
+	keys sync.Map // key -> *trackedKey
+
+	mu       sync.Mutex
+	lru      *list.List // most recently accessed at the front, as of the last reordering
+	lastScan time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newRefreshAheadScheduler(cfg *RefreshAheadConfig) *refreshAheadScheduler {
+	maxRefreshes := helpers.TernaryOp(cfg.MaxRefreshesPerSecond > 0, cfg.MaxRefreshesPerSecond, defaultRefreshAheadMaxRefreshesPerSecond)
+	return &refreshAheadScheduler{
+		maxTrackedKeys: helpers.TernaryOp(cfg.MaxTrackedKeys > 0, cfg.MaxTrackedKeys, defaultRefreshAheadMaxTrackedKeys),
+		minAccessRate:  helpers.TernaryOp(cfg.MinAccessRate > 0, cfg.MinAccessRate, defaultRefreshAheadMinAccessRate),
+		lead:           cfg.Lead,
+		budget:         rate.NewLimiter(rate.Limit(maxRefreshes), int(math.Ceil(maxRefreshes))),
+		lru:            list.New(),
+		lastScan:       time.Now(),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+func (s *refreshAheadScheduler) start(interval time.Duration) {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.scan(time.Now())
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler. Refreshes that were already submitted to the worker pool are not cancelled.
+func (s *refreshAheadScheduler) Stop() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+// touch records an access of key if it is already tracked, and reports whether it was.
+func (s *refreshAheadScheduler) touch(key string, updatedAt time.Time) bool {
+	k, ok := s.lookup(key)
+	if ok {
+		k.touch(updatedAt)
+	}
+	return ok
+}
+
+func (s *refreshAheadScheduler) lookup(key string) (*trackedKey, bool) {
+	k, ok := s.keys.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return k.(*trackedKey), true
+}
+
+// track records an access of key. The refresh function is only kept for keys that are not tracked yet.
+func (s *refreshAheadScheduler) track(key, rpcCallName string, updatedAt time.Time, hardTTL time.Duration, refresh func(done func(error))) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.touch(key, updatedAt) {
+		return
+	}
+
+	if s.lru.Len() >= s.maxTrackedKeys {
+		s.evictLocked()
+	}
+
+	k := &trackedKey{
+		key:         key,
+		rpcCallName: rpcCallName,
+		hardTTL:     hardTTL,
+		refresh:     refresh,
+		hits:        1,
+		updatedAt:   updatedAt.UnixNano(),
+		orderedHits: 1,
+	}
+	k.elem = s.lru.PushFront(k)
+	s.keys.Store(key, k)
+}
+
+// evictLocked evicts the least recently accessed key. Keys at the back of the LRU list that were accessed since they
+// were last moved are moved to the front first. It must be called with s.mu held.
+func (s *refreshAheadScheduler) evictLocked() {
+	for i := s.lru.Len(); i > 1; i-- {
+		k := s.lru.Back().Value.(*trackedKey)
+		hits := atomic.LoadInt64(&k.hits)
+		if hits == k.orderedHits {
+			break
+		}
+		k.orderedHits = hits
+		s.lru.MoveToFront(k.elem)
+	}
+	oldest := s.lru.Remove(s.lru.Back()).(*trackedKey)
+	s.keys.Delete(oldest.key)
+}
+
+// scan updates the access rate of every tracked key and refreshes the keys that are due, within the refresh budget.
+func (s *refreshAheadScheduler) scan(now time.Time) {
+	var due []*trackedKey
+
+	s.mu.Lock()
+	elapsed := now.Sub(s.lastScan).Seconds()
+	s.lastScan = now
+	// walking from the back and moving the keys accessed since the last scan to the front keeps their relative order.
+	for elem := s.lru.Back(); elem != nil; {
+		k := elem.Value.(*trackedKey)
+		elem = elem.Prev()
+
+		hits := atomic.LoadInt64(&k.hits)
+		if elapsed > 0 {
+			instant := float64(hits-k.scannedHits) / elapsed
+			k.rate = helpers.TernaryOp(k.rate == 0, instant, (k.rate+instant)/2)
+		}
+		k.scannedHits = hits
+		if hits != k.orderedHits {
+			k.orderedHits = hits
+			s.lru.MoveToFront(k.elem)
+		}
+
+		if k.refreshing || k.rate < s.minAccessRate {
+			continue
+		}
+		lead := helpers.TernaryOp(s.lead > 0, s.lead, k.hardTTL/defaultRefreshAheadLeadDivisor)
+		if now.Before(k.lastUpdate().Add(k.hardTTL - lead)) {
+			continue
+		}
+		due = append(due, k)
+	}
+	tracked := s.lru.Len()
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	if !isSkipMetrics() {
+		metricsProvider.EmitRefreshAheadTrackedKeysMetric(ctx, tracked)
+	}
+
+	for _, k := range due {
+		if !s.budget.AllowN(now, 1) {
+			if !isSkipMetrics() {
+				metricsProvider.IncreaseRefreshAheadBudgetExhaustedMetric(ctx, k.rpcCallName)
+			}
+			continue
+		}
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseRefreshAheadMetric(ctx, k.rpcCallName)
+		}
+		s.setRefreshing(k, true)
+
+		k := k
+		getWorkerPool().Submit(&backgroundTask{
+			ctx:         ctx,
+			rpcCallName: k.rpcCallName,
+			onDrop:      func() { s.setRefreshing(k, false) },
+			run: func() {
+				k.refresh(func(err error) {
+					s.mu.Lock()
+					defer s.mu.Unlock()
+					k.refreshing = false
+					if err == nil {
+						k.advance(time.Now())
+					}
+				})
+			},
+		})
+	}
+}
+
+func (s *refreshAheadScheduler) setRefreshing(k *trackedKey, refreshing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.refreshing = refreshing
+}
+
+// InjectRefreshAheadConfig starts the refresh-ahead scheduler, stopping the previous one. A nil config disables it.
+func InjectRefreshAheadConfig(cfg *RefreshAheadConfig) {
+	var s *refreshAheadScheduler
+	if cfg != nil {
+		s = newRefreshAheadScheduler(cfg)
+		s.start(helpers.TernaryOp(cfg.Interval > 0, cfg.Interval, defaultRefreshAheadInterval))
+	}
+	if old := swapRefreshAhead(s); old != nil {
+		old.Stop()
+	}
+}
+
+// swapRefreshAhead replaces the refresh-ahead scheduler and returns the previous one, which the caller must stop.
+func swapRefreshAhead(s *refreshAheadScheduler) *refreshAheadScheduler {
+	refreshAheadMu.Lock()
+	defer refreshAheadMu.Unlock()
+	old := refreshAhead
+	refreshAhead = s
+	return old
+}
+
+func getRefreshAhead() *refreshAheadScheduler {
+	refreshAheadMu.RLock()
+	defer refreshAheadMu.RUnlock()
+	return refreshAhead
+}
+
+// trackRefreshAhead records an access of key with the refresh-ahead scheduler, if it is enabled. The refresh of a key
+// is built once, when the key starts being tracked, and runs on a fresh background context: the metadata, credentials
+// and values of the request that first touched the key are neither reused by later refreshes nor kept in memory. Only
+// the entry size limit set by the options of the call and the key dimensions read from metadata are carried over.
+// Keys of calls with key dimensions read with an Extract function are not tracked, as their refresh could not
+// reproduce them.
+func trackRefreshAhead[response any](ctx context.Context, key string, rpcCall func(ctx context.Context) (*response, error),
+	softTTL, hardTTL time.Duration, rpcCallName string, writeToCache func(*response) bool, updatedTS int64) {
+	s := getRefreshAhead()
+	if s == nil || getCallDimensions(ctx).extracted || s.touch(key, time.Unix(updatedTS, 0)) {
+		return
+	}
+
+	refreshCtx := copyCallDimensions(copyCallEntrySizeConfig(context.Background(), ctx), ctx)
+	s.track(key, rpcCallName, time.Unix(updatedTS, 0), hardTTL, func(done func(error)) {
+		ctx := refreshCtx
+		refreshWithRetries(ctx, rpcCallName, rpcCall, func(resp *response, err error) {
+			if errors.Is(err, ErrDownstreamLimited) {
+				done(err)
+				return
+			}
+			if err != nil {
+				if !isSkipMetrics() {
+					metricsProvider.IncreaseRefreshErrorMetric(ctx, rpcCallName)
+				}
+				reportError(ctx, rpcCallName, errors.Wrap(err, "refresh ahead failed"))
+				done(err)
+				return
+			}
+
+			if !writeCache(ctx, key, resp, softTTL, hardTTL, rpcCallName, writeToCache) {
+				done(errRefreshNotWritten)
+				return
+			}
+			done(nil)
+		})
+	})
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/bytedance/heimdall/cache"
+)
+
+func TestRefreshAheadScheduler(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    *RefreshAheadConfig
+		accesses  int
+		updatedAt time.Duration
+		refreshes int32
+	}{
+		{
+			name:      "hot key near hard ttl is refreshed",
+			config:    &RefreshAheadConfig{MinAccessRate: 1},
+			accesses:  10,
+			updatedAt: -58 * time.Second,
+			refreshes: 1,
+		}, {
+			name:      "cold key is left to expire",
+			config:    &RefreshAheadConfig{MinAccessRate: 100},
+			accesses:  10,
+			updatedAt: -58 * time.Second,
+			refreshes: 0,
+		}, {
+			name:      "hot key far from hard ttl is not refreshed",
+			config:    &RefreshAheadConfig{MinAccessRate: 1},
+			accesses:  10,
+			updatedAt: -10 * time.Second,
+			refreshes: 0,
+		}, {
+			name:      "custom lead",
+			config:    &RefreshAheadConfig{MinAccessRate: 1, Lead: time.Minute},
+			accesses:  10,
+			updatedAt: -10 * time.Second,
+			refreshes: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRefreshAheadScheduler(tt.config)
+			now := time.Now()
+			s.lastScan = now.Add(-time.Second)
+
+			var refreshes int32
+			for i := 0; i < tt.accesses; i++ {
+				s.track("key", "rpcCallName", now.Add(tt.updatedAt), time.Minute, func(done func(error)) {
+					atomic.AddInt32(&refreshes, 1)
+					done(nil)
+				})
+			}
+
+			s.scan(now)
+			waitForBackgroundTasks()
+			assert.Equal(t, tt.refreshes, atomic.LoadInt32(&refreshes))
+		})
+	}
+}
+
+func TestRefreshAheadSchedulerEvictsLeastRecentlyAccessed(t *testing.T) {
+	s := newRefreshAheadScheduler(&RefreshAheadConfig{MaxTrackedKeys: 2})
+	for _, key := range []string{"a", "b", "a", "c"} {
+		s.track(key, "rpcCallName", time.Now(), time.Minute, func(done func(error)) { done(nil) })
+	}
+
+	assert.Equal(t, 2, s.lru.Len())
+	for key, tracked := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, ok := s.lookup(key)
+		assert.Equal(t, tracked, ok, key)
+	}
+}
+
+func TestRefreshAheadSchedulerBudget(t *testing.T) {
+	s := newRefreshAheadScheduler(&RefreshAheadConfig{MinAccessRate: 1, MaxRefreshesPerSecond: 1})
+	now := time.Now()
+	s.lastScan = now.Add(-time.Second)
+
+	var refreshes int32
+	for _, key := range []string{"a", "b", "c"} {
+		for i := 0; i < 5; i++ {
+			s.track(key, "rpcCallName", now.Add(-time.Minute), time.Minute, func(done func(error)) {
+				atomic.AddInt32(&refreshes, 1)
+				done(nil)
+			})
+		}
+	}
+
+	s.scan(now)
+	waitForBackgroundTasks()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&refreshes))
+}
+
+func TestTrackRefreshAheadOnlyAdvancesWrittenKeys(t *testing.T) {
+	for _, written := range []bool{false, true} {
+		waitForBackgroundTasks()
+		client := &MockedCache{}
+		cacheProvider = &cache.Client{GetAPI: client, SetAPI: client}
+		s := newRefreshAheadScheduler(&RefreshAheadConfig{MinAccessRate: 1})
+		swapRefreshAhead(s)
+		now := time.Now()
+		s.lastScan = now.Add(-time.Second)
+		updatedAt := time.Unix(now.Add(-time.Minute).Unix(), 0)
+
+		rpcCall := func(ctx context.Context) (*string, error) {
+			resp := "response"
+			return &resp, nil
+		}
+		writeToCache := func(*string) bool { return written }
+		for i := 0; i < 5; i++ {
+			trackRefreshAhead(context.Background(), "key", rpcCall, time.Second, time.Minute, "rpcCallName", writeToCache, updatedAt.Unix())
+		}
+
+		s.scan(now)
+		waitForBackgroundTasks()
+		swapRefreshAhead(nil)
+
+		k, _ := s.lookup("key")
+		assert.Equal(t, written, k.lastUpdate().After(updatedAt))
+	}
+}
+
+func TestTrackRefreshAheadKeyDimensions(t *testing.T) {
+	tenant := KeyDimension{Name: "tenant", MetadataKey: "x-tenant-id"}
+	locale := KeyDimension{Name: "locale", Extract: func(ctx context.Context) (string, bool) { return "en", true }}
+	tests := []struct {
+		name       string
+		dimensions []KeyDimension
+		tracked    bool
+	}{
+		{
+			name:       "metadata dimension",
+			dimensions: []KeyDimension{tenant},
+			tracked:    true,
+		}, {
+			name:       "extracted dimension",
+			dimensions: []KeyDimension{tenant, locale},
+			tracked:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waitForBackgroundTasks()
+			client := &MockedCache{}
+			cacheProvider = &cache.Client{GetAPI: client, SetAPI: client}
+			InjectKeyConfig(&KeyConfig{Dimensions: tt.dimensions}, nil)
+			s := newRefreshAheadScheduler(&RefreshAheadConfig{MinAccessRate: 1})
+			swapRefreshAhead(s)
+			t.Cleanup(func() {
+				swapRefreshAhead(nil)
+				InjectKeyConfig(nil, nil)
+			})
+			now := time.Now()
+			s.lastScan = now.Add(-time.Second)
+
+			var refreshed metadata.MD
+			rpcCall := func(ctx context.Context) (*string, error) {
+				refreshed, _ = metadata.FromOutgoingContext(ctx)
+				resp := "response"
+				return &resp, nil
+			}
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "first", "authorization", "secret")
+			ctx = withCallDimensions(ctx, "rpcCallName", nil)
+			for i := 0; i < 5; i++ {
+				trackRefreshAhead(ctx, "key", rpcCall, time.Second, time.Minute, "rpcCallName", func(*string) bool { return true },
+					now.Add(-time.Minute).Unix())
+			}
+
+			s.scan(now)
+			waitForBackgroundTasks()
+
+			_, tracked := s.lookup("key")
+			assert.Equal(t, tt.tracked, tracked)
+			if tt.tracked {
+				// only the key dimensions are carried over to the refresh
+				assert.Equal(t, metadata.Pairs("x-tenant-id", "first"), refreshed)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 2 * time.Second
+	defaultRetryMultiplier     = 2
+	defaultRetryBudgetRatio    = 0.1
+	maxRetryBudgetTokens       = 100
+
+	refreshAttemptSuccess         = "success"
+	refreshAttemptFailure         = "failure"
+	refreshAttemptBudgetExhausted = "budget_exhausted"
+)
+
+var (
+	refreshRetryConfig *RetryConfig
+	refreshRetryBudget *retryBudget
+)
+
+// errRetryDropped is passed to a refresh whose retry was dropped by the worker pool.
+var errRetryDropped = errors.New("retry dropped by the worker pool")
+
+// RetryConfig is the configuration for retrying failed background soft TTL refreshes with exponential backoff.
+// Zero values fall back to the defaults.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of attempts of a refresh, including the first one. Defaults to 3.
+	MaxAttempts int `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" xml:"max_attempts,omitempty"`
+	// InitialBackoff is the wait before the first retry. Defaults to 100ms.
+	InitialBackoff time.Duration `json:"initial_backoff,omitempty" yaml:"initial_backoff,omitempty" xml:"initial_backoff,omitempty"`
+	// MaxBackoff caps the wait between retries. Defaults to 2 seconds.
+	MaxBackoff time.Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty" xml:"max_backoff,omitempty"`
+	// Multiplier is the factor the backoff grows by after every retry. Defaults to 2.
+	Multiplier float64 `json:"multiplier,omitempty" yaml:"multiplier,omitempty" xml:"multiplier,omitempty"`
+	// Jitter is the fraction of the backoff that is randomised, in the range [0, 1]. 0 disables jitter.
+	Jitter float64 `json:"jitter,omitempty" yaml:"jitter,omitempty" xml:"jitter,omitempty"`
+	// BudgetRatio is the number of retries allowed per refresh, on average. This keeps retries from amplifying a
+	// downstream outage: once the budget is spent, failed refreshes are not retried. Defaults to 0.1.
+	BudgetRatio float64 `json:"budget_ratio,omitempty" yaml:"budget_ratio,omitempty" xml:"budget_ratio,omitempty"`
+	// MinRetriesPerSecond is a number of retries per second that is always allowed, regardless of the budget,
+	// so that methods with few refreshes can still be retried. 0 disables it.
+	MinRetriesPerSecond float64 `json:"min_retries_per_second,omitempty" yaml:"min_retries_per_second,omitempty" xml:"min_retries_per_second,omitempty"`
+}
+
+func (c *RetryConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.MaxAttempts < 0 || c.InitialBackoff < 0 || c.MaxBackoff < 0 || c.BudgetRatio < 0 || c.MinRetriesPerSecond < 0 {
+		return errors.Errorf("retry config cannot have negative values")
+	}
+
+	if c.Multiplier != 0 && c.Multiplier < 1 {
+		return errors.Errorf("retry multiplier must be at least 1")
+	}
+
+	if c.Jitter < 0 || c.Jitter > 1 {
+		return errors.Errorf("retry jitter must be in the range [0, 1]")
+	}
+	return nil
+}
+
+// backoff returns the wait before the given retry, starting at 1.
+func (c *RetryConfig) backoff(retry int) time.Duration {
+	initial := helpers.TernaryOp(c.InitialBackoff > 0, c.InitialBackoff, defaultRetryInitialBackoff)
+	maxBackoff := helpers.TernaryOp(c.MaxBackoff > 0, c.MaxBackoff, defaultRetryMaxBackoff)
+	multiplier := helpers.TernaryOp(c.Multiplier > 0, c.Multiplier, defaultRetryMultiplier)
+
+	backoff := math.Min(float64(initial)*math.Pow(multiplier, float64(retry-1)), float64(maxBackoff))
+	backoff -= backoff * c.Jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// retryBudget allows a fraction of the refreshes to be retried. Every refresh deposits a fraction of a token and every
+// retry withdraws a whole token.
+type retryBudget struct {
+	mu      sync.Mutex
+	ratio   float64
+	tokens  float64
+	reserve *rate.Limiter
+}
+
+func newRetryBudget(cfg *RetryConfig) *retryBudget {
+	b := &retryBudget{ratio: helpers.TernaryOp(cfg.BudgetRatio > 0, cfg.BudgetRatio, defaultRetryBudgetRatio)}
+	if cfg.MinRetriesPerSecond > 0 {
+		b.reserve = rate.NewLimiter(rate.Limit(cfg.MinRetriesPerSecond), int(math.Ceil(cfg.MinRetriesPerSecond)))
+	}
+	return b
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.tokens+b.ratio, maxRetryBudgetTokens)
+}
+
+func (b *retryBudget) withdraw() bool {
+	if b.reserve != nil && b.reserve.Allow() {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// InjectRefreshRetryConfig sets the retry configuration of background soft TTL refreshes. A nil config disables retries.
+func InjectRefreshRetryConfig(cfg *RetryConfig) {
+	refreshRetryConfig = cfg
+	refreshRetryBudget = nil
+	if cfg != nil {
+		refreshRetryBudget = newRetryBudget(cfg)
+	}
+}
+
+// refreshWithRetries calls rpcCall to refresh a stale entry, retrying with exponential backoff while the retry budget
+// allows it, and passes the outcome to done. Every attempt is admitted by the downstream limiter of the method, bounded
+// by the refresh timeout, and its outcome is recorded. The first attempt runs on the calling goroutine; retries are
+// submitted to the worker pool once their backoff has elapsed, so that neither a worker nor downstream capacity is held
+// between attempts. If an attempt is rejected by the limiter, done receives an error wrapping ErrDownstreamLimited.
+func refreshWithRetries[response any](ctx context.Context, rpcCallName string, rpcCall func(ctx context.Context) (*response, error),
+	done func(*response, error)) {
+	cfg, budget := refreshRetryConfig, refreshRetryBudget
+	maxAttempts := 1
+	if cfg != nil {
+		maxAttempts = helpers.TernaryOp(cfg.MaxAttempts > 0, cfg.MaxAttempts, defaultRetryMaxAttempts)
+		budget.deposit()
+	}
+	runRefreshAttempt(ctx, rpcCallName, rpcCall, cfg, budget, maxAttempts, 1, done)
+}
+
+func runRefreshAttempt[response any](ctx context.Context, rpcCallName string, rpcCall func(ctx context.Context) (*response, error),
+	cfg *RetryConfig, budget *retryBudget, maxAttempts, attempt int, done func(*response, error)) {
+	resp, err := refreshAttempt(ctx, rpcCallName, rpcCall)
+	if errors.Is(err, ErrDownstreamLimited) {
+		done(nil, err)
+		return
+	}
+	if err == nil {
+		recordRefreshAttempt(ctx, rpcCallName, attempt, refreshAttemptSuccess)
+		done(resp, nil)
+		return
+	}
+	recordRefreshAttempt(ctx, rpcCallName, attempt, refreshAttemptFailure)
+
+	if attempt >= maxAttempts {
+		done(nil, errors.Wrapf(err, "refresh failed after %d attempts", attempt))
+		return
+	}
+	if !budget.withdraw() {
+		recordRefreshAttempt(ctx, rpcCallName, attempt+1, refreshAttemptBudgetExhausted)
+		done(nil, errors.Wrap(err, "refresh failed and retry budget is exhausted"))
+		return
+	}
+
+	abandon := func(reason error) {
+		done(nil, errors.Wrapf(err, "refresh retry abandoned: %v", reason))
+	}
+	retry := func() {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			abandon(ctxErr)
+			return
+		}
+		runRefreshAttempt(ctx, rpcCallName, rpcCall, cfg, budget, maxAttempts, attempt+1, done)
+	}
+	scheduleRetry(ctx, rpcCallName, cfg.backoff(attempt), retry, abandon)
+}
+
+// refreshAttempt makes a single refresh call once the downstream limiter admits it.
+func refreshAttempt[response any](ctx context.Context, rpcCallName string, rpcCall func(ctx context.Context) (*response, error)) (*response, error) {
+	acquireCtx, cancel := backgroundContext(ctx, refreshTimeout)
+	release, err := acquireDownstream(acquireCtx, rpcCallName)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	attemptCtx, cancel := backgroundContext(ctx, refreshTimeout)
+	defer cancel()
+	return rpcCall(attemptCtx)
+}
+
+// scheduledRetries holds the retries waiting for their backoff to elapse, so that Shutdown can abandon them instead of
+// letting them fire once the worker pool is gone.
+var scheduledRetries = struct {
+	mu      sync.Mutex
+	closed  bool
+	abandon map[*time.Timer]func(error)
+}{abandon: make(map[*time.Timer]func(error))}
+
+// scheduleRetry submits retry to the worker pool once d has elapsed. abandon is called with the reason instead if
+// Heimdall is shut down first or if the worker pool drops the retry.
+func scheduleRetry(ctx context.Context, rpcCallName string, d time.Duration, retry func(), abandon func(error)) {
+	scheduledRetries.mu.Lock()
+	defer scheduledRetries.mu.Unlock()
+	if scheduledRetries.closed {
+		abandon(errShuttingDown)
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		scheduledRetries.mu.Lock()
+		_, ok := scheduledRetries.abandon[timer]
+		delete(scheduledRetries.abandon, timer)
+		scheduledRetries.mu.Unlock()
+		if !ok {
+			// abandoned by Shutdown
+			return
+		}
+		getWorkerPool().Submit(&backgroundTask{
+			ctx:         ctx,
+			rpcCallName: rpcCallName,
+			run:         retry,
+			onDrop:      func() { abandon(errRetryDropped) },
+		})
+	})
+	scheduledRetries.abandon[timer] = abandon
+}
+
+// abandonScheduledRetries cancels the retries waiting for their backoff and stops new ones from being scheduled.
+func abandonScheduledRetries() {
+	scheduledRetries.mu.Lock()
+	scheduledRetries.closed = true
+	pending := scheduledRetries.abandon
+	scheduledRetries.abandon = make(map[*time.Timer]func(error))
+	scheduledRetries.mu.Unlock()
+
+	for timer, abandon := range pending {
+		timer.Stop()
+		abandon(errShuttingDown)
+	}
+}
+
+// resumeScheduledRetries lets retries be scheduled again once Heimdall is initialised anew after Shutdown.
+func resumeScheduledRetries() {
+	scheduledRetries.mu.Lock()
+	defer scheduledRetries.mu.Unlock()
+	scheduledRetries.closed = false
+}
+
+func recordRefreshAttempt(ctx context.Context, rpcCallName string, attempt int, outcome string) {
+	if !isSkipMetrics() {
+		metricsProvider.IncreaseRefreshAttemptMetric(ctx, rpcCallName, attempt, outcome)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestRefreshWithRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *RetryConfig
+		failures int
+		calls    int
+		err      bool
+	}{
+		{
+			name:     "retries disabled",
+			config:   nil,
+			failures: 1,
+			calls:    1,
+			err:      true,
+		}, {
+			name:     "succeeds after retries",
+			config:   &RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, BudgetRatio: 10},
+			failures: 2,
+			calls:    3,
+			err:      false,
+		}, {
+			name:     "gives up after max attempts",
+			config:   &RetryConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond, BudgetRatio: 10},
+			failures: 5,
+			calls:    2,
+			err:      true,
+		}, {
+			name:     "retry budget exhausted",
+			config:   &RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, BudgetRatio: 0.5},
+			failures: 5,
+			calls:    1,
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InjectRefreshRetryConfig(tt.config)
+			defer InjectRefreshRetryConfig(nil)
+
+			calls := 0
+			rpcCall := func(ctx context.Context) (*string, error) {
+				calls++
+				if calls <= tt.failures {
+					return nil, errors.New("rpc failed")
+				}
+				resp := "response"
+				return &resp, nil
+			}
+
+			resp, err := refreshAndWait(context.Background(), rpcCall)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, !tt.err, resp != nil)
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := &RetryConfig{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Multiplier: 2}
+	assert.Equal(t, 10*time.Millisecond, cfg.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, cfg.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, cfg.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, cfg.backoff(4))
+
+	cfg.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		backoff := cfg.backoff(1)
+		assert.GreaterOrEqual(t, backoff, 5*time.Millisecond)
+		assert.LessOrEqual(t, backoff, 10*time.Millisecond)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(&RetryConfig{BudgetRatio: 0.5})
+	assert.False(t, budget.withdraw())
+	budget.deposit()
+	budget.deposit()
+	assert.True(t, budget.withdraw())
+	assert.False(t, budget.withdraw())
+
+	budget = newRetryBudget(&RetryConfig{BudgetRatio: 0.5, MinRetriesPerSecond: 1})
+	assert.True(t, budget.withdraw())
+	assert.False(t, budget.withdraw())
+}
+
+func TestRefreshWithRetriesAcquiresLimiterPerAttempt(t *testing.T) {
+	InjectRefreshRetryConfig(&RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, BudgetRatio: 10})
+	defer InjectRefreshRetryConfig(nil)
+	InjectDownstreamLimitConfig(&DownstreamLimitConfig{RateLimit: 1, Burst: 1, Policy: constants.StaleLimitPolicy}, nil)
+	defer InjectDownstreamLimitConfig(nil, nil)
+
+	calls := 0
+	rpcCall := func(ctx context.Context) (*string, error) {
+		calls++
+		return nil, errors.New("rpc failed")
+	}
+
+	// the retry is over the rate limit
+	_, err := refreshAndWait(context.Background(), rpcCall)
+	assert.ErrorIs(t, err, ErrDownstreamLimited)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRefreshWithRetriesBackoffCancelled(t *testing.T) {
+	InjectRefreshRetryConfig(&RetryConfig{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond, BudgetRatio: 10})
+	defer InjectRefreshRetryConfig(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	rpcCall := func(ctx context.Context) (*string, error) {
+		calls++
+		cancel()
+		return nil, errors.New("rpc failed")
+	}
+
+	_, err := refreshAndWait(ctx, rpcCall)
+	assert.ErrorContains(t, err, context.Canceled.Error())
+	assert.Equal(t, 1, calls)
+}
+
+func TestRefreshWithRetriesDoesNotHoldWorker(t *testing.T) {
+	InjectWorkerPoolConfig(&WorkerPoolConfig{Workers: 1})
+	defer InjectWorkerPoolConfig(nil)
+	InjectRefreshRetryConfig(&RetryConfig{MaxAttempts: 2, InitialBackoff: time.Hour, BudgetRatio: 10})
+	defer InjectRefreshRetryConfig(nil)
+
+	rpcCall := func(ctx context.Context) (*string, error) {
+		return nil, errors.New("rpc failed")
+	}
+	result := make(chan error, 1)
+	submitBackgroundTask(context.Background(), "rpcCallName", func() {
+		refreshWithRetries(context.Background(), "rpcCallName", rpcCall, func(_ *string, err error) { result <- err })
+	})
+
+	// the only worker is free while the retry waits for its backoff
+	ran := make(chan struct{})
+	submitBackgroundTask(context.Background(), "rpcCallName", func() { close(ran) })
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("worker is held by the retry backoff")
+	}
+
+	abandonScheduledRetries()
+	defer resumeScheduledRetries()
+	assert.ErrorContains(t, <-result, errShuttingDown.Error())
+}
+
+// refreshAndWait calls refreshWithRetries and waits for its outcome.
+func refreshAndWait(ctx context.Context, rpcCall func(ctx context.Context) (*string, error)) (*string, error) {
+	type outcome struct {
+		resp *string
+		err  error
+	}
+	result := make(chan outcome, 1)
+	refreshWithRetries(ctx, "rpcCallName", rpcCall, func(resp *string, err error) { result <- outcome{resp, err} })
+	o := <-result
+	return o.resp, o.err
+}
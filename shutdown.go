@@ -0,0 +1,182 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// errShuttingDown is returned by background work cut short by Shutdown.
+var errShuttingDown = errors.New("heimdall is shutting down")
+
+// lifecycle tracks the calls that use the cache and metrics providers, so that Shutdown only closes the providers
+// once nothing uses them anymore.
+var lifecycle = struct {
+	mu      sync.RWMutex
+	closing bool
+	// stopping is closed when Shutdown starts.
+	stopping chan struct{}
+	// calls tracks the calls of the current initialisation. It is replaced, never reset, when Heimdall is initialised
+	// anew, as calls abandoned by a previous Shutdown may still be in flight.
+	calls *callTracker
+}{calls: &callTracker{}}
+
+// callTracker counts the calls in flight.
+type callTracker struct {
+	inFlight sync.WaitGroup
+	count    int64
+}
+
+func (t *callTracker) exit() {
+	atomic.AddInt64(&t.count, -1)
+	t.inFlight.Done()
+}
+
+// enterCall registers a call that uses the cache and metrics providers. It returns false once Shutdown has started,
+// in which case the call must bypass the cache. Every successful enterCall must be followed by exit on the returned
+// tracker.
+func enterCall() (*callTracker, bool) {
+	lifecycle.mu.RLock()
+	defer lifecycle.mu.RUnlock()
+	if lifecycle.closing {
+		return nil, false
+	}
+	lifecycle.calls.inFlight.Add(1)
+	atomic.AddInt64(&lifecycle.calls.count, 1)
+	return lifecycle.calls, true
+}
+
+// shutdownStarted returns a channel that is closed when Shutdown starts.
+func shutdownStarted() <-chan struct{} {
+	lifecycle.mu.Lock()
+	defer lifecycle.mu.Unlock()
+	if lifecycle.stopping == nil {
+		lifecycle.stopping = make(chan struct{})
+	}
+	return lifecycle.stopping
+}
+
+func isShuttingDown() bool {
+	lifecycle.mu.RLock()
+	defer lifecycle.mu.RUnlock()
+	return lifecycle.closing
+}
+
+// startLifecycle lets calls use the cache again after Shutdown, when Heimdall is initialised anew. Calls abandoned by
+// the previous Shutdown keep exiting their own tracker.
+func startLifecycle() {
+	lifecycle.mu.Lock()
+	defer lifecycle.mu.Unlock()
+	lifecycle.closing = false
+	lifecycle.stopping = nil
+	lifecycle.calls = &callTracker{}
+	resumeScheduledRetries()
+}
+
+// ShutdownReport describes what Shutdown was not able to complete before its deadline.
+type ShutdownReport struct {
+	// AbandonedCalls is the number of calls that were still using the cache when the deadline was hit.
+	AbandonedCalls int
+	// AbandonedTasks is the number of background cache writes and refreshes that were still queued or running
+	// when the deadline was hit.
+	AbandonedTasks int
+	// DrainErr is the error returned while waiting for calls and background tasks to drain, if any.
+	DrainErr error
+	// CacheCloseErr is the error returned while closing the cache provider, if any.
+	CacheCloseErr error
+	// MetricsCloseErr is the error returned while closing the metrics provider, if any.
+	MetricsCloseErr error
+}
+
+// Err returns the first error encountered during shutdown, or nil if shutdown completed cleanly.
+func (r *ShutdownReport) Err() error {
+	for _, err := range []error{r.DrainErr, r.CacheCloseErr, r.MetricsCloseErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown gracefully shuts Heimdall down. It stops accepting new calls and background work, stops the refresh-ahead
+// scheduler, waits for in-flight calls and pending cache writes and refreshes until ctx is done, writes the call
+// journal one last time, then closes the cache and metrics providers if they implement io.Closer.
+// If ctx is done before everything has drained, the providers are left open for the abandoned work and the report
+// tells what was abandoned.
+// Calls made after Shutdown bypass the cache and go straight to the RPC. Calling Shutdown again before Heimdall is
+// initialised anew does nothing.
+func Shutdown(ctx context.Context) (*ShutdownReport, error) {
+	report := &ShutdownReport{}
+
+	lifecycle.mu.Lock()
+	if lifecycle.closing {
+		lifecycle.mu.Unlock()
+		return report, nil
+	}
+	lifecycle.closing = true
+	if lifecycle.stopping == nil {
+		lifecycle.stopping = make(chan struct{})
+	}
+	close(lifecycle.stopping)
+	calls := lifecycle.calls
+	lifecycle.mu.Unlock()
+
+	InjectRefreshAheadConfig(nil)
+	abandonScheduledRetries()
+	if err := waitForCalls(ctx, calls); err != nil {
+		report.DrainErr = err
+		report.AbandonedCalls = int(atomic.LoadInt64(&calls.count))
+	}
+	pool := getWorkerPool()
+	if err := pool.Shutdown(ctx); err != nil && report.DrainErr == nil {
+		report.DrainErr = err
+	}
+	report.AbandonedTasks = pool.Pending()
+
+	stopCallJournal()
+	if report.DrainErr != nil {
+		return report, report.Err()
+	}
+
+	if cacheProvider != nil {
+		report.CacheCloseErr = cacheProvider.Close()
+	}
+	if !isSkipMetrics() {
+		report.MetricsCloseErr = metricsProvider.Close()
+	}
+
+	return report, report.Err()
+}
+
+func waitForCalls(ctx context.Context, calls *callTracker) error {
+	done := make(chan struct{})
+	go func() {
+		calls.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "calls did not drain, %d calls in flight", atomic.LoadInt64(&calls.count))
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/cache"
+	"github.com/bytedance/heimdall/metrics"
+)
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name           string
+		block          bool
+		inFlightCall   bool
+		abandonedTasks int
+		abandonedCalls int
+		err            bool
+	}{
+		{
+			name: "drained",
+		}, {
+			name:           "background task abandoned",
+			block:          true,
+			abandonedTasks: 1,
+			err:            true,
+		}, {
+			name:           "call abandoned",
+			inFlightCall:   true,
+			abandonedCalls: 1,
+			err:            true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waitForBackgroundTasks()
+			InjectWorkerPoolConfig(nil)
+			cacheClient := &closableCache{}
+			metricsClient := &closableMetrics{}
+			InjectCacheProvider(&cache.Client{GetAPI: cacheClient, SetAPI: cacheClient})
+			InjectMetricsProvider(&metrics.Client{IncreaseMetricAPI: metricsClient})
+			t.Cleanup(func() {
+				InjectWorkerPoolConfig(nil)
+				InjectSkipCache(false)
+				InjectMetricsProvider(nil)
+				startLifecycle()
+			})
+
+			block := make(chan struct{})
+			defer close(block)
+			blocking := tt.block
+			submitBackgroundTask(context.Background(), "rpcCallName", func() {
+				if blocking {
+					<-block
+				}
+			})
+			if tt.inFlightCall {
+				calls, ok := enterCall()
+				assert.True(t, ok)
+				defer calls.exit()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			report, err := Shutdown(ctx)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.abandonedTasks, report.AbandonedTasks)
+			assert.Equal(t, tt.abandonedCalls, report.AbandonedCalls)
+			// providers still used by abandoned work are left open
+			assert.Equal(t, !tt.err, cacheClient.closed == 1)
+			assert.Equal(t, !tt.err, metricsClient.closed)
+			assert.True(t, isSkipCache())
+			_, ok := enterCall()
+			assert.False(t, ok)
+
+			// a second Shutdown does nothing
+			report, err = Shutdown(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, &ShutdownReport{}, report)
+			assert.Equal(t, !tt.err, cacheClient.closed == 1)
+		})
+	}
+}
+
+type closableCache struct {
+	MockedCache
+	closed int
+}
+
+func (c *closableCache) Close() error {
+	c.closed++
+	return nil
+}
+
+type closableMetrics struct {
+	closed bool
+}
+
+func (c *closableMetrics) IncreaseCacheHitMetric(ctx context.Context, metricName string)     {}
+func (c *closableMetrics) IncreaseCacheMissMetric(ctx context.Context, metricName string)    {}
+func (c *closableMetrics) IncreaseCacheSoftHitMetric(ctx context.Context, metricName string) {}
+
+func (c *closableMetrics) Close() error {
+	c.closed = true
+	return nil
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultWarmConcurrency = 8
+	maxWarmReportErrors    = 100
+
+	warmSuccess = "success"
+	warmFailure = "failure"
+)
+
+var (
+	warmConfig  *WarmConfig
+	warmMethods sync.Map // rpc call name -> func(ctx context.Context, entry journalEntry) error
+)
+
+// WarmConfig is the configuration for warming the cache up with Warm. Zero values fall back to the defaults.
+type WarmConfig struct {
+	// Concurrency is the maximum number of warm-up calls in flight. Defaults to 8.
+	Concurrency int `json:"concurrency,omitempty" yaml:"concurrency,omitempty" xml:"concurrency,omitempty"`
+	// JournalSize is the number of recently seen calls kept in a journal in the cache, so that the next version can
+	// be warmed from them with PreviousVersionLoader. 0 disables the journal. The journal requires a Namespace, which
+	// identifies the service in the journal key.
+	JournalSize int `json:"journal_size,omitempty" yaml:"journal_size,omitempty" xml:"journal_size,omitempty"`
+	// JournalFlushInterval is how often the journal is written to the cache. Defaults to 30 seconds.
+	JournalFlushInterval time.Duration `json:"journal_flush_interval,omitempty" yaml:"journal_flush_interval,omitempty" xml:"journal_flush_interval,omitempty"`
+	// JournalTTL is how long the journal is kept in the cache after its last write. Defaults to 7 days.
+	JournalTTL time.Duration `json:"journal_ttl,omitempty" yaml:"journal_ttl,omitempty" xml:"journal_ttl,omitempty"`
+	// OnProgress is called after every warm-up call with the number of calls that succeeded and failed so far.
+	// This field is optional.
+	OnProgress func(succeeded, failed int) `json:"-" yaml:"-" xml:"-"`
+}
+
+func (c *WarmConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Concurrency < 0 || c.JournalSize < 0 || c.JournalFlushInterval < 0 || c.JournalTTL < 0 {
+		return errors.Errorf("warm config cannot have negative values")
+	}
+	return nil
+}
+
+// WarmCall is a single call made to warm the cache up.
+type WarmCall struct {
+	// Name is the rpc call name, used for metrics and errors.
+	Name string
+	// Call makes the call through Heimdall, so that its response is cached under the current version.
+	Call func(ctx context.Context) error
+}
+
+// Loader yields the calls to warm the cache up with. It must stop and return when yield returns false.
+type Loader func(ctx context.Context, yield func(call WarmCall) bool) error
+
+// WarmReport describes the outcome of Warm.
+type WarmReport struct {
+	// Succeeded is the number of warm-up calls that succeeded.
+	Succeeded int
+	// Failed is the number of warm-up calls that failed.
+	Failed int
+	// Errs holds the errors of the loaders and of the first failed calls.
+	Errs []error
+}
+
+// Err returns the first error encountered while warming up, or nil if every call succeeded.
+func (r *WarmReport) Err() error {
+	if len(r.Errs) == 0 {
+		return nil
+	}
+	return r.Errs[0]
+}
+
+func (r *WarmReport) addErr(err error) {
+	if len(r.Errs) < maxWarmReportErrors {
+		r.Errs = append(r.Errs, err)
+	}
+}
+
+// NewWarmCall creates a warm-up call for a grpc call method. It uses the global default set hard and soft TTLs.
+func NewWarmCall[request, response any](grpcFunc func(ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error), req *request, opts ...grpc.CallOption) WarmCall {
+	return NewWarmCallWithTTL(grpcFunc, req, defaultSoftTTL, defaultHardTTL, opts...)
+}
+
+// NewWarmCallWithTTL creates a warm-up call for a grpc call method. It uses user defined hard and soft TTLs.
+func NewWarmCallWithTTL[request, response any](grpcFunc func(ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error), req *request, softTTL, hardTTL time.Duration, opts ...grpc.CallOption) WarmCall {
+	return WarmCall{
+		Name: helpers.GetFunctionName(grpcFunc),
+		Call: func(ctx context.Context) error {
+			_, err := GRPCCallWithTTL(grpcFunc, ctx, req, softTTL, hardTTL, opts...)
+			return err
+		},
+	}
+}
+
+// RegisterWarmMethod registers a grpc call method so that PreviousVersionLoader can replay the calls journaled for it.
+// Calls are replayed with the outgoing gRPC metadata of their key dimensions. Register the methods at startup, before
+// calling Warm.
+func RegisterWarmMethod[request, response any](grpcFunc func(ctx context.Context, req *request, opts ...grpc.CallOption) (*response, error), opts ...grpc.CallOption) {
+	warmMethods.Store(helpers.GetFunctionName(grpcFunc), func(ctx context.Context, entry journalEntry) error {
+		req := new(request)
+		if err := json.ConfigStd.UnmarshalFromString(entry.Request, req); err != nil {
+			return errors.Wrap(err, "unable to unmarshal journaled request")
+		}
+		if entry.Metadata != "" {
+			md := metadata.MD{}
+			if err := json.ConfigStd.UnmarshalFromString(entry.Metadata, &md); err != nil {
+				return errors.Wrap(err, "unable to unmarshal journaled metadata")
+			}
+			ctx = appendOutgoingMetadata(ctx, md)
+		}
+		_, err := GRPCCallWithTTL(grpcFunc, ctx, req, entry.SoftTTL, entry.HardTTL, opts...)
+		return err
+	})
+}
+
+// PreviousVersionLoader is a Loader that replays the calls recently seen under previousVersion, most recent first.
+// Calls of methods that were not registered with RegisterWarmMethod are skipped.
+func PreviousVersionLoader(previousVersion string) Loader {
+	return func(ctx context.Context, yield func(call WarmCall) bool) error {
+		entries, err := readJournal(ctx, previousVersion)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			method, ok := warmMethods.Load(entry.RPCCallName)
+			if !ok {
+				continue
+			}
+			replay, entry := method.(func(context.Context, journalEntry) error), entry
+			call := WarmCall{
+				Name: entry.RPCCallName,
+				Call: func(ctx context.Context) error { return replay(ctx, entry) },
+			}
+			if !yield(call) {
+				return nil
+			}
+		}
+		return nil
+	}
+}
+
+// Warm warms the cache up with the calls yielded by the loaders, with bounded concurrency. Call it before the service
+// reports ready, for example after a deploy with a new Version. It returns once every call is done or ctx is done.
+// Failed calls are reported through metrics and the ErrorHook, and do not stop the warm-up.
+func Warm(ctx context.Context, loaders ...Loader) (*WarmReport, error) {
+	report := &WarmReport{}
+	if isSkipCache() {
+		report.addErr(errors.Errorf("cache is disabled, there is nothing to warm"))
+		return report, report.Err()
+	}
+
+	concurrency, onProgress := defaultWarmConcurrency, func(succeeded, failed int) {}
+	if cfg := warmConfig; cfg != nil {
+		concurrency = helpers.TernaryOp(cfg.Concurrency > 0, cfg.Concurrency, concurrency)
+		if cfg.OnProgress != nil {
+			onProgress = cfg.OnProgress
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, concurrency)
+		callCtx = withWarmUp(ctx)
+	)
+	done := func(call WarmCall, err error) {
+		outcome := helpers.TernaryOp(err == nil, warmSuccess, warmFailure)
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseWarmMetric(ctx, call.Name, outcome)
+		}
+		if err != nil {
+			reportError(ctx, call.Name, errors.Wrap(err, "warm-up call failed"))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			report.Failed++
+			report.addErr(errors.Wrapf(err, "warm-up call %s failed", call.Name))
+		} else {
+			report.Succeeded++
+		}
+		onProgress(report.Succeeded, report.Failed)
+	}
+
+	yield := func(call WarmCall) bool {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			done(call, call.Call(callCtx))
+		}()
+		return true
+	}
+
+	for _, load := range loaders {
+		if err := load(ctx, yield); err != nil {
+			mu.Lock()
+			report.addErr(errors.Wrap(err, "warm-up loader failed"))
+			mu.Unlock()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		report.addErr(errors.Wrap(err, "warm-up did not complete"))
+	}
+	return report, report.Err()
+}
+
+// writeWarmUpCache writes the response of a warm-up call to the cache before the call returns, so that a call counted
+// as succeeded by Warm has its entry in the cache. A failed write fails the call.
+func writeWarmUpCache[response any](ctx context.Context, key string, resp *response, softTTL, hardTTL time.Duration,
+	rpcCallName string, writeToCache func(*response) bool) error {
+	writeCtx, cancel := backgroundContext(ctx, writeTimeout)
+	defer cancel()
+
+	if _, err := updateCache(writeCtx, key, resp, softTTL, hardTTL, rpcCallName, writeToCache); err != nil {
+		if !isSkipMetrics() {
+			metricsProvider.IncreaseCacheWriteErrorMetric(ctx, rpcCallName)
+		}
+		return errors.Wrap(err, "warm-up cache write failed")
+	}
+	return nil
+}
+
+// InjectWarmConfig sets the warm-up configuration and starts the call journal under the current version, stopping the
+// previous one. A nil config disables the journal.
+func InjectWarmConfig(cfg *WarmConfig) {
+	warmConfig = cfg
+	stopCallJournal()
+	if cfg == nil || cfg.JournalSize == 0 {
+		return
+	}
+
+	j := newRecentCallJournal(version, cfg.JournalSize, helpers.TernaryOp(cfg.JournalTTL > 0, cfg.JournalTTL, defaultJournalTTL))
+	j.start(helpers.TernaryOp(cfg.JournalFlushInterval > 0, cfg.JournalFlushInterval, defaultJournalFlushInterval))
+	if old := swapCallJournal(j); old != nil {
+		old.Stop()
+	}
+}
+
+func stopCallJournal() {
+	if j := swapCallJournal(nil); j != nil {
+		j.Stop()
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/bytedance/heimdall/cache"
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+func TestWarm(t *testing.T) {
+	waitForBackgroundTasks()
+	mockCache(map[string]any{})
+	InjectWarmConfig(&WarmConfig{Concurrency: 2})
+	t.Cleanup(func() { InjectWarmConfig(nil) })
+
+	failingCall := func(ctx context.Context, req *TestRPCRequest, opts ...grpc.CallOption) (*TestRPCResponse, error) {
+		return nil, errors.New("rpc failed")
+	}
+
+	c := &TestRPCClient{}
+	loader := func(ctx context.Context, yield func(call WarmCall) bool) error {
+		for _, userID := range []string{"1", "2", "3"} {
+			if !yield(NewWarmCall(c.TestRPCCall, &TestRPCRequest{UserID: userID})) {
+				return nil
+			}
+		}
+		yield(NewWarmCall(failingCall, &TestRPCRequest{UserID: "4"}))
+		return nil
+	}
+
+	report, err := Warm(context.Background(), loader)
+	assert.Error(t, err)
+	assert.Equal(t, 3, report.Succeeded)
+	assert.Equal(t, 1, report.Failed)
+	assert.Len(t, report.Errs, 1)
+
+	// the entries are written before Warm returns
+	for _, userID := range []string{"1", "2", "3"} {
+		keyFor, err := newKeyGenerator(context.Background(), &TestRPCRequest{UserID: userID},
+			helpers.GetFunctionName(c.TestRPCCall), defaultSoftTTL, defaultHardTTL, nil)
+		assert.NoError(t, err)
+		_, err = cacheProvider.Get(context.Background(), keyFor(""))
+		assert.NoError(t, err, userID)
+	}
+}
+
+func TestWarmFailedWrite(t *testing.T) {
+	waitForBackgroundTasks()
+	client := &failingSetCache{err: errors.New("cache unavailable")}
+	cacheProvider = &cache.Client{GetAPI: client, SetAPI: client}
+
+	c := &TestRPCClient{}
+	report, err := Warm(context.Background(), func(ctx context.Context, yield func(call WarmCall) bool) error {
+		yield(NewWarmCall(c.TestRPCCall, testReq))
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 0, report.Succeeded)
+	assert.Equal(t, 1, report.Failed)
+}
+
+func TestWarmFromPreviousVersion(t *testing.T) {
+	waitForBackgroundTasks()
+	data := map[string]any{}
+	mockCache(data)
+	InjectVersion("v1")
+	InjectKeyFormat(constants.SHA512KeyHasher, "service")
+	InjectKeyProvider(&staticKeyProvider{current: "1", keys: map[string][]byte{"1": make([]byte, 32)}}, false)
+	InjectWarmConfig(&WarmConfig{JournalSize: 10, JournalFlushInterval: time.Hour})
+	t.Cleanup(func() {
+		InjectWarmConfig(nil)
+		InjectKeyProvider(nil, false)
+		InjectKeyFormat(constants.SHA512KeyHasher, "")
+		InjectVersion("")
+	})
+
+	c := &TestRPCClient{}
+	_, err := GRPCCall(c.TestRPCCall, context.Background(), testReq)
+	assert.NoError(t, err)
+	stopCallJournal()
+	waitForBackgroundTasks()
+
+	// the journal is encrypted like any other entry, and kept out of the keys matched by ScanPattern
+	journal, ok := data[journalKey("v1")].([]byte)
+	assert.True(t, ok)
+	assert.NotContains(t, string(journal), testReq.UserID)
+	assert.NotRegexp(t, "^heimdall:service:", journalKey("v1"))
+
+	InjectVersion("v2")
+	RegisterWarmMethod(c.TestRPCCall)
+	report, err := Warm(context.Background(), PreviousVersionLoader("v1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Succeeded)
+
+	waitForBackgroundTasks()
+	keyFor, err := newKeyGenerator(context.Background(), testReq, helpers.GetFunctionName(c.TestRPCCall), defaultSoftTTL, defaultHardTTL, nil)
+	assert.NoError(t, err)
+	_, err = cacheProvider.Get(context.Background(), keyFor("v2"))
+	assert.NoError(t, err)
+}
+
+func TestWarmReplaysKeyDimensions(t *testing.T) {
+	waitForBackgroundTasks()
+	mockCache(map[string]any{})
+	InjectVersion("v1")
+	InjectKeyFormat(constants.SHA512KeyHasher, "service")
+	InjectKeyConfig(&KeyConfig{Dimensions: []KeyDimension{{Name: "tenant", MetadataKey: "x-tenant-id", Required: true}}},
+		map[string]*KeyConfig{"extracted": {Dimensions: []KeyDimension{{Name: "locale", Extract: func(ctx context.Context) (string, bool) {
+			return "en", true
+		}}}}})
+	InjectWarmConfig(&WarmConfig{JournalSize: 10, JournalFlushInterval: time.Hour})
+	t.Cleanup(func() {
+		InjectWarmConfig(nil)
+		InjectKeyConfig(nil, nil)
+		InjectKeyFormat(constants.SHA512KeyHasher, "")
+		InjectVersion("")
+	})
+
+	c := &dimensionClient{}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "first", "authorization", "secret")
+	_, err := GRPCCall(c.TestRPCCall, ctx, testReq)
+	assert.NoError(t, err)
+	// calls with extracted dimensions are not journaled
+	recordCall(withCallDimensions(ctx, "extracted", nil), testReq, "extracted", time.Second, time.Minute)
+	assert.Equal(t, 1, getCallJournal().lru.Len())
+	stopCallJournal()
+	waitForBackgroundTasks()
+
+	InjectVersion("v2")
+	RegisterWarmMethod(c.TestRPCCall)
+	c.tenants = nil
+	report, err := Warm(context.Background(), PreviousVersionLoader("v1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Succeeded)
+	assert.Equal(t, [][]string{{"first"}}, c.tenants)
+	assert.False(t, c.authorized)
+
+	waitForBackgroundTasks()
+	keyFor, err := newKeyGenerator(ctx, testReq, helpers.GetFunctionName(c.TestRPCCall), defaultSoftTTL, defaultHardTTL, nil)
+	assert.NoError(t, err)
+	_, err = cacheProvider.Get(context.Background(), keyFor("v2"))
+	assert.NoError(t, err)
+}
+
+// dimensionClient records the tenants of its calls, and whether they carried credentials.
+type dimensionClient struct {
+	tenants    [][]string
+	authorized bool
+}
+
+func (c *dimensionClient) TestRPCCall(ctx context.Context, req *TestRPCRequest, opts ...grpc.CallOption) (*TestRPCResponse, error) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.tenants = append(c.tenants, md.Get("x-tenant-id"))
+	c.authorized = len(md.Get("authorization")) > 0
+	return testResp, nil
+}
+
+func TestRecentCallJournal(t *testing.T) {
+	j := newRecentCallJournal("v1", 2, time.Hour)
+	for _, req := range []string{"a", "b", "a", "c"} {
+		j.record(journalEntry{RPCCallName: "rpcCallName", Request: req})
+	}
+
+	var reqs []string
+	for elem := j.lru.Front(); elem != nil; elem = elem.Next() {
+		reqs = append(reqs, elem.Value.(journalEntry).Request)
+	}
+	assert.Equal(t, []string{"c", "a"}, reqs)
+}
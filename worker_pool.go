@@ -0,0 +1,264 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/constants"
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultWorkerPoolWorkers   = 32
+	defaultWorkerPoolQueueSize = 1024
+)
+
+var (
+	workerPool   atomic.Value // *backgroundWorkerPool
+	workerPoolMu sync.Mutex
+)
+
+// WorkerPoolConfig is the configuration for the background worker pool that performs cache writes and soft TTL refreshes.
+// Zero values fall back to the defaults.
+type WorkerPoolConfig struct {
+	// Workers is the number of goroutines processing background tasks. Defaults to 32.
+	Workers int `json:"workers,omitempty" yaml:"workers,omitempty" xml:"workers,omitempty"`
+	// QueueSize is the maximum number of background tasks waiting for a free worker. Defaults to 1024.
+	QueueSize int `json:"queue_size,omitempty" yaml:"queue_size,omitempty" xml:"queue_size,omitempty"`
+	// DropPolicy decides what happens to a background task when the queue is full. Defaults to DropOldestPolicy.
+	DropPolicy constants.DropPolicyType `json:"drop_policy,omitempty" yaml:"drop_policy,omitempty" xml:"drop_policy,omitempty"`
+}
+
+func (c *WorkerPoolConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Workers < 0 {
+		return errors.Errorf("worker pool workers cannot be negative")
+	}
+
+	if c.QueueSize < 0 {
+		return errors.Errorf("worker pool queue size cannot be negative")
+	}
+
+	if c.DropPolicy < constants.DropOldestPolicy || c.DropPolicy > constants.RunInlinePolicy {
+		return errors.Errorf("invalid worker pool drop policy specified.")
+	}
+	return nil
+}
+
+type backgroundTask struct {
+	ctx         context.Context
+	rpcCallName string
+	run         func()
+	// onDrop is called if the task is dropped instead of being run. This field is optional.
+	onDrop func()
+}
+
+// backgroundWorkerPool runs cache writes and refreshes on a fixed number of goroutines fed by a bounded queue.
+type backgroundWorkerPool struct {
+	tasks      chan *backgroundTask
+	dropPolicy constants.DropPolicyType
+	pending    int64 // queued and running tasks
+
+	mu     sync.RWMutex
+	closed bool
+	// next is the pool that replaced this one, if any.
+	next    *backgroundWorkerPool
+	workers sync.WaitGroup
+}
+
+func newWorkerPool(cfg *WorkerPoolConfig) *backgroundWorkerPool {
+	workers, queueSize, dropPolicy := defaultWorkerPoolWorkers, defaultWorkerPoolQueueSize, constants.DropOldestPolicy
+	if cfg != nil {
+		workers = helpers.TernaryOp(cfg.Workers > 0, cfg.Workers, workers)
+		queueSize = helpers.TernaryOp(cfg.QueueSize > 0, cfg.QueueSize, queueSize)
+		dropPolicy = cfg.DropPolicy
+	}
+
+	p := &backgroundWorkerPool{
+		tasks:      make(chan *backgroundTask, queueSize),
+		dropPolicy: dropPolicy,
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *backgroundWorkerPool) work() {
+	defer p.workers.Done()
+	for task := range p.tasks {
+		task.run()
+		atomic.AddInt64(&p.pending, -1)
+	}
+}
+
+// Submit queues a task on the worker pool. If the queue is full, the drop policy of the pool is applied.
+// Tasks submitted to a pool that has been replaced by InjectWorkerPoolConfig are handed to its successor.
+// It returns false if the task was dropped.
+func (p *backgroundWorkerPool) Submit(task *backgroundTask) bool {
+	p.mu.RLock()
+	closed, next := p.closed, p.next
+	queued, runInline := false, false
+	if !closed {
+		queued, runInline = p.offer(task)
+	}
+	p.mu.RUnlock()
+
+	switch {
+	case queued:
+		return true
+	case runInline:
+		// the task runs outside the lock so that a pending Shutdown does not block other submitters meanwhile.
+		task.run()
+		return true
+	case closed && next != nil:
+		return next.Submit(task)
+	}
+
+	p.drop(task)
+	return false
+}
+
+// offer tries to queue the task, applying the drop policy if the queue is full. It must be called with p.mu held.
+func (p *backgroundWorkerPool) offer(task *backgroundTask) (queued, runInline bool) {
+	if p.enqueue(task) {
+		return true, false
+	}
+
+	switch p.dropPolicy {
+	case constants.RunInlinePolicy:
+		return false, true
+	case constants.DropOldestPolicy:
+		select {
+		case oldest := <-p.tasks:
+			atomic.AddInt64(&p.pending, -1)
+			p.drop(oldest)
+		default:
+		}
+		return p.enqueue(task), false
+	}
+	return false, false
+}
+
+// handOff stops the pool from accepting new tasks and forwards later submissions to next.
+// Tasks already queued are still run by the workers of this pool.
+func (p *backgroundWorkerPool) handOff(next *backgroundWorkerPool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next = next
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+}
+
+// Shutdown stops the pool from accepting new tasks and waits for the queued tasks to drain.
+// If ctx is done before the queue is drained, the remaining tasks are left running in the background.
+func (p *backgroundWorkerPool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "worker pool did not drain, %d tasks pending", p.Pending())
+	}
+}
+
+// Pending returns the number of tasks that are queued or running.
+func (p *backgroundWorkerPool) Pending() int {
+	return int(atomic.LoadInt64(&p.pending))
+}
+
+func (p *backgroundWorkerPool) enqueue(task *backgroundTask) bool {
+	atomic.AddInt64(&p.pending, 1)
+	select {
+	case p.tasks <- task:
+		if !isSkipMetrics() {
+			metricsProvider.EmitWorkerPoolQueueDepthMetric(task.ctx, len(p.tasks))
+		}
+		return true
+	default:
+		atomic.AddInt64(&p.pending, -1)
+		return false
+	}
+}
+
+func (p *backgroundWorkerPool) drop(task *backgroundTask) {
+	if task.onDrop != nil {
+		task.onDrop()
+	}
+	if !isSkipMetrics() {
+		metricsProvider.IncreaseWorkerPoolDroppedTaskMetric(task.ctx, task.rpcCallName)
+	}
+}
+
+// InjectWorkerPoolConfig replaces the background worker pool with one built from cfg.
+// The previous pool stops accepting tasks, hands new submissions to the replacement and shuts down once its queued
+// tasks have drained.
+func InjectWorkerPoolConfig(cfg *WorkerPoolConfig) {
+	p := newWorkerPool(cfg)
+
+	workerPoolMu.Lock()
+	old, _ := workerPool.Load().(*backgroundWorkerPool)
+	workerPool.Store(p)
+	workerPoolMu.Unlock()
+
+	if old != nil {
+		old.handOff(p)
+		go old.Shutdown(context.Background())
+	}
+}
+
+func getWorkerPool() *backgroundWorkerPool {
+	if p, ok := workerPool.Load().(*backgroundWorkerPool); ok {
+		return p
+	}
+
+	workerPoolMu.Lock()
+	defer workerPoolMu.Unlock()
+	if p, ok := workerPool.Load().(*backgroundWorkerPool); ok {
+		return p
+	}
+	p := newWorkerPool(nil)
+	workerPool.Store(p)
+	return p
+}
+
+func submitBackgroundTask(ctx context.Context, rpcCallName string, run func()) {
+	getWorkerPool().Submit(&backgroundTask{ctx: ctx, rpcCallName: rpcCallName, run: run})
+}
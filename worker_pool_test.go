@@ -0,0 +1,174 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+func TestWorkerPoolDropPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		dropPolicy constants.DropPolicyType
+		accepted   []bool
+		ran        []int
+	}{
+		{
+			name:       "drop oldest",
+			dropPolicy: constants.DropOldestPolicy,
+			accepted:   []bool{true, true, true},
+			ran:        []int{2},
+		}, {
+			name:       "drop newest",
+			dropPolicy: constants.DropNewestPolicy,
+			accepted:   []bool{true, false, false},
+			ran:        []int{0},
+		}, {
+			name:       "run inline",
+			dropPolicy: constants.RunInlinePolicy,
+			accepted:   []bool{true, true, true},
+			ran:        []int{1, 2, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1, DropPolicy: tt.dropPolicy})
+
+			var (
+				mu  sync.Mutex
+				ran []int
+			)
+			record := func(i int) func() {
+				return func() {
+					mu.Lock()
+					defer mu.Unlock()
+					ran = append(ran, i)
+				}
+			}
+
+			// occupy the only worker so that the queue fills up
+			block := make(chan struct{})
+			started := make(chan struct{})
+			p.Submit(&backgroundTask{ctx: context.Background(), run: func() {
+				close(started)
+				<-block
+			}})
+			<-started
+
+			for i, accepted := range tt.accepted {
+				assert.Equal(t, accepted, p.Submit(&backgroundTask{ctx: context.Background(), run: record(i)}))
+			}
+
+			close(block)
+			assert.NoError(t, p.Shutdown(context.Background()))
+			assert.Equal(t, tt.ran, ran)
+		})
+	}
+}
+
+func TestWorkerPoolShutdown(t *testing.T) {
+	p := newWorkerPool(&WorkerPoolConfig{Workers: 2, QueueSize: 10})
+
+	var (
+		mu    sync.Mutex
+		count int
+	)
+	for i := 0; i < 10; i++ {
+		p.Submit(&backgroundTask{ctx: context.Background(), run: func() {
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			count++
+		}})
+	}
+
+	assert.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, 10, count)
+	assert.Equal(t, 0, p.Pending())
+	assert.False(t, p.Submit(&backgroundTask{ctx: context.Background(), run: func() {}}))
+}
+
+func TestWorkerPoolShutdownDeadline(t *testing.T) {
+	p := newWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 10})
+
+	block := make(chan struct{})
+	defer close(block)
+	p.Submit(&backgroundTask{ctx: context.Background(), run: func() { <-block }})
+	p.Submit(&backgroundTask{ctx: context.Background(), run: func() {}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, p.Shutdown(ctx))
+	assert.Equal(t, 2, p.Pending())
+}
+
+func TestWorkerPoolHandOff(t *testing.T) {
+	old := newWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1})
+	next := newWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1})
+	old.handOff(next)
+
+	ran := make(chan struct{})
+	assert.True(t, old.Submit(&backgroundTask{ctx: context.Background(), run: func() { close(ran) }}))
+	<-ran
+	assert.NoError(t, old.Shutdown(context.Background()))
+	assert.NoError(t, next.Shutdown(context.Background()))
+}
+
+func TestWorkerPoolRunInlineDoesNotBlockSubmit(t *testing.T) {
+	p := newWorkerPool(&WorkerPoolConfig{Workers: 1, QueueSize: 1, DropPolicy: constants.RunInlinePolicy})
+
+	block := make(chan struct{})
+	started := make(chan struct{})
+	p.Submit(&backgroundTask{ctx: context.Background(), run: func() {
+		close(started)
+		<-block
+	}})
+	<-started
+	p.Submit(&backgroundTask{ctx: context.Background(), run: func() {}})
+
+	inline := make(chan struct{})
+	go p.Submit(&backgroundTask{ctx: context.Background(), run: func() {
+		close(inline)
+		<-block
+	}})
+	<-inline
+
+	// a pending Shutdown must not keep other submitters waiting for the inline task to finish
+	shutdown := make(chan error)
+	go func() { shutdown <- p.Shutdown(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, p.Submit(&backgroundTask{ctx: context.Background(), run: func() {}}))
+
+	close(block)
+	assert.NoError(t, <-shutdown)
+}
+
+// waitForBackgroundTasks waits for background tasks submitted by previous tests, as they write to the global cache provider.
+func waitForBackgroundTasks() {
+	deadline := time.Now().Add(5 * time.Second)
+	for getWorkerPool().Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+}
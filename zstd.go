@@ -0,0 +1,312 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+
+	json "github.com/bytedance/sonic"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/bytedance/heimdall/helpers"
+)
+
+const (
+	defaultZstdLevel = 3
+
+	// dictionary training parameters: segments of dictSegmentSize bytes are scored by the number of samples their
+	// dictGramSize-byte substrings appear in.
+	dictSegmentSize = 64
+	dictGramSize    = 8
+)
+
+var (
+	zstdMu    sync.RWMutex
+	zstdCodec *zstdCodecs
+)
+
+// ZstdConfig is the configuration of ZstdCompressionType. Zero values fall back to the defaults.
+type ZstdConfig struct {
+	// Level is the zstd compression level, from 1 (fastest) to 22 (best compression). Defaults to 3.
+	Level int `json:"level,omitempty" yaml:"level,omitempty" xml:"level,omitempty"`
+	// Dictionaries are pre-trained dictionaries keyed by rpc call name. Small and repetitive responses compress much
+	// better with a dictionary. The dictionary id is recorded in every compressed entry, so entries written with a
+	// dictionary can be read as long as it stays configured. This field is optional.
+	Dictionaries map[string]*ZstdDictionary `json:"dictionaries,omitempty" yaml:"dictionaries,omitempty" xml:"dictionaries,omitempty"`
+}
+
+// ZstdDictionary is a zstd dictionary, either in the zstd dictionary format (as produced by "zstd --train") or raw
+// content (as produced by TrainZstdDictionary).
+type ZstdDictionary struct {
+	// ID identifies the dictionary in compressed entries. It must be unique and non-zero. It is ignored for
+	// dictionaries in the zstd dictionary format, which carry their own id.
+	ID uint32 `json:"id,omitempty" yaml:"id,omitempty" xml:"id,omitempty"`
+	// Content is the dictionary.
+	Content []byte `json:"content,omitempty" yaml:"content,omitempty" xml:"content,omitempty"`
+}
+
+// id returns the id of the dictionary and whether it is in the zstd dictionary format.
+func (d *ZstdDictionary) id() (uint32, bool) {
+	if info, err := zstd.InspectDictionary(d.Content); err == nil {
+		return info.ID(), true
+	}
+	return d.ID, false
+}
+
+func (c *ZstdConfig) validate() error {
+	if c == nil {
+		return nil
+	}
+
+	if c.Level < 0 || c.Level > 22 {
+		return errors.Errorf("zstd level must be in the range [1, 22], or 0 for the default")
+	}
+
+	ids := map[uint32]string{}
+	for rpcCallName, dict := range c.Dictionaries {
+		if dict == nil || len(dict.Content) == 0 {
+			return errors.Errorf("zstd dictionary of %s is empty", rpcCallName)
+		}
+		id, _ := dict.id()
+		if id == 0 {
+			return errors.Errorf("zstd dictionary of %s must have a non-zero id", rpcCallName)
+		}
+		if other, ok := ids[id]; ok && other != rpcCallName {
+			return errors.Errorf("zstd dictionary id %d is used by both %s and %s", id, other, rpcCallName)
+		}
+		ids[id] = rpcCallName
+	}
+	return nil
+}
+
+// zstdCodecs holds an encoder per dictionary and a decoder that knows every dictionary. zstd encoders and decoders
+// are safe for concurrent use with EncodeAll and DecodeAll.
+type zstdCodecs struct {
+	encoder     *zstd.Encoder
+	dictEncoder map[string]*zstd.Encoder
+	decoder     *zstd.Decoder
+}
+
+func newZstdCodecs(cfg *ZstdConfig) (*zstdCodecs, error) {
+	if cfg == nil {
+		cfg = &ZstdConfig{}
+	}
+	level := zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(helpers.TernaryOp(cfg.Level > 0, cfg.Level, defaultZstdLevel)))
+
+	encoder, err := zstd.NewWriter(nil, level)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create zstd encoder")
+	}
+
+	codecs := &zstdCodecs{encoder: encoder, dictEncoder: make(map[string]*zstd.Encoder, len(cfg.Dictionaries))}
+	decoderOpts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	for rpcCallName, dict := range cfg.Dictionaries {
+		var encoderDict zstd.EOption
+		if id, formatted := dict.id(); formatted {
+			encoderDict = zstd.WithEncoderDict(dict.Content)
+			decoderOpts = append(decoderOpts, zstd.WithDecoderDicts(dict.Content))
+		} else {
+			encoderDict = zstd.WithEncoderDictRaw(id, dict.Content)
+			decoderOpts = append(decoderOpts, zstd.WithDecoderDictRaw(id, dict.Content))
+		}
+
+		if codecs.dictEncoder[rpcCallName], err = zstd.NewWriter(nil, level, encoderDict); err != nil {
+			return nil, errors.Wrapf(err, "cannot create zstd encoder with the dictionary of %s", rpcCallName)
+		}
+	}
+
+	if codecs.decoder, err = zstd.NewReader(nil, decoderOpts...); err != nil {
+		return nil, errors.Wrap(err, "cannot create zstd decoder")
+	}
+	return codecs, nil
+}
+
+// InjectZstdConfig sets the level and dictionaries of ZstdCompressionType.
+func InjectZstdConfig(cfg *ZstdConfig) error {
+	codecs, err := newZstdCodecs(cfg)
+	if err != nil {
+		return err
+	}
+
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+	zstdCodec = codecs
+	return nil
+}
+
+func getZstdCodecs() (*zstdCodecs, error) {
+	zstdMu.RLock()
+	codecs := zstdCodec
+	zstdMu.RUnlock()
+	if codecs != nil {
+		return codecs, nil
+	}
+
+	zstdMu.Lock()
+	defer zstdMu.Unlock()
+	if zstdCodec == nil {
+		var err error
+		if zstdCodec, err = newZstdCodecs(nil); err != nil {
+			return nil, err
+		}
+	}
+	return zstdCodec, nil
+}
+
+func zstdCompression(data []byte, rpcCallName string) ([]byte, error) {
+	codecs, err := getZstdCodecs()
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, ok := codecs.dictEncoder[rpcCallName]
+	if !ok {
+		encoder = codecs.encoder
+	}
+	return encoder.EncodeAll(data, nil), nil
+}
+
+func zstdDecompression(data []byte) ([]byte, error) {
+	codecs, err := getZstdCodecs()
+	if err != nil {
+		return nil, err
+	}
+
+	decompressedDat, err := codecs.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decompress zstd")
+	}
+	return decompressedDat, nil
+}
+
+// TrainZstdDictionary trains a raw content zstd dictionary of at most size bytes from the entries cached under
+// cacheKeys, for example the keys returned by HotKeys. Keys that cannot be read are skipped. Use the dictionary as the
+// Content of a ZstdDictionary for the rpc call name the entries belong to.
+func TrainZstdDictionary(ctx context.Context, cacheKeys []string, size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, errors.Errorf("dictionary size must be positive")
+	}
+
+	samples := make([][]byte, 0, len(cacheKeys))
+	for _, key := range cacheKeys {
+		cacheVal, err := fetchFromCache(ctx, key, "")
+		if err != nil {
+			continue
+		}
+		sample, err := json.Marshal(cacheVal)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+
+	if len(samples) == 0 {
+		return nil, errors.Errorf("no cached entries to train the dictionary from")
+	}
+	return buildZstdDictionary(samples, size), nil
+}
+
+// buildZstdDictionary selects the segments of the samples whose substrings appear in the most samples, a simplified
+// version of the COVER algorithm used by "zstd --train". The best segments are placed at the end of the dictionary,
+// where zstd finds them with the shortest offsets.
+func buildZstdDictionary(samples [][]byte, size int) []byte {
+	frequency := map[string]int{}
+	for _, sample := range samples {
+		seen := map[string]bool{}
+		for i := 0; i+dictGramSize <= len(sample); i++ {
+			gram := string(sample[i : i+dictGramSize])
+			if !seen[gram] {
+				seen[gram] = true
+				frequency[gram]++
+			}
+		}
+	}
+
+	score := func(data []byte) int {
+		total := 0
+		for j := 0; j+dictGramSize <= len(data); j++ {
+			total += frequency[string(data[j:j+dictGramSize])]
+		}
+		return total
+	}
+
+	segments := &segmentHeap{}
+	for _, sample := range samples {
+		for start := 0; start < len(sample); start += dictSegmentSize {
+			end := start + dictSegmentSize
+			if end > len(sample) {
+				end = len(sample)
+			}
+			*segments = append(*segments, dictSegment{data: sample[start:end], score: score(sample[start:end])})
+		}
+	}
+	heap.Init(segments)
+
+	// scores only decrease as segments are selected, so a popped segment whose updated score is still the best is
+	// the best segment overall (lazy greedy selection).
+	var selected [][]byte
+	for remaining := size; remaining > 0 && segments.Len() > 0; {
+		best := heap.Pop(segments).(dictSegment)
+		if best.score = score(best.data); best.score == 0 {
+			continue
+		}
+		if segments.Len() > 0 && best.score < (*segments)[0].score {
+			heap.Push(segments, best)
+			continue
+		}
+
+		if len(best.data) > remaining {
+			best.data = best.data[:remaining]
+		}
+		selected = append(selected, best.data)
+		remaining -= len(best.data)
+
+		// substrings already in the dictionary do not make other segments more valuable.
+		for j := 0; j+dictGramSize <= len(best.data); j++ {
+			delete(frequency, string(best.data[j:j+dictGramSize]))
+		}
+	}
+
+	dict := make([]byte, 0, size)
+	for i := len(selected) - 1; i >= 0; i-- {
+		dict = append(dict, selected[i]...)
+	}
+	return dict
+}
+
+type dictSegment struct {
+	data  []byte
+	score int
+}
+
+// segmentHeap is a max-heap of dictionary segments by score.
+type segmentHeap []dictSegment
+
+func (h segmentHeap) Len() int           { return len(h) }
+func (h segmentHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h segmentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *segmentHeap) Push(x any)        { *h = append(*h, x.(dictSegment)) }
+
+func (h *segmentHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
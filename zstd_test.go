@@ -0,0 +1,119 @@
+/*
+ * Copyright 2022 ByteDance Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package heimdall
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	json "github.com/bytedance/sonic"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bytedance/heimdall/constants"
+)
+
+type testUserProfile struct {
+	UserID      string   `json:"user_id"`
+	DisplayName string   `json:"display_name"`
+	Country     string   `json:"country"`
+	Preferences []string `json:"preferences"`
+	Verified    bool     `json:"verified"`
+}
+
+func testProfileCacheValue(i int) *CacheValue {
+	data, _ := json.MarshalString(&testUserProfile{
+		UserID:      fmt.Sprintf("%d", 1000+i),
+		DisplayName: fmt.Sprintf("user %d", i),
+		Country:     "SG",
+		Preferences: []string{"notifications_enabled", "dark_mode", "language_en"},
+		Verified:    i%2 == 0,
+	})
+	return &CacheValue{UpdatedTS: time.Now().Unix(), SoftTTL: time.Minute, Data: data}
+}
+
+func TestZstdDictionary(t *testing.T) {
+	waitForBackgroundTasks()
+	data := map[string]any{}
+	mockCache(data)
+	InjectCompressionLibrary(constants.NoCompressionType)
+
+	ctx := context.Background()
+	var keys []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		assert.NoError(t, setCacheValue(ctx, key, testProfileCacheValue(i), time.Minute, "rpcCallName"))
+		keys = append(keys, key)
+	}
+
+	dict, err := TrainZstdDictionary(ctx, keys, 1024)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, dict)
+	assert.LessOrEqual(t, len(dict), 1024)
+
+	cfg := &ZstdConfig{Dictionaries: map[string]*ZstdDictionary{"rpcCallName": {ID: 7, Content: dict}}}
+	assert.NoError(t, cfg.validate())
+	assert.NoError(t, InjectZstdConfig(cfg))
+	t.Cleanup(func() { _ = InjectZstdConfig(nil) })
+
+	cacheVal := testProfileCacheValue(100)
+	withDict, err := compressStruct(ctx, cacheVal, constants.ZstdCompressionType, "rpcCallName")
+	assert.NoError(t, err)
+	withoutDict, err := compressStruct(ctx, cacheVal, constants.ZstdCompressionType, "otherRpcCallName")
+	assert.NoError(t, err)
+	assert.Less(t, len(withDict), len(withoutDict))
+
+	got := &CacheValue{}
+	assert.NoError(t, DecompressStruct(ctx, withDict, got, constants.ZstdCompressionType))
+	assert.Equal(t, cacheVal, got)
+}
+
+func TestZstdConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *ZstdConfig
+		err    bool
+	}{
+		{
+			name:   "default",
+			config: &ZstdConfig{},
+			err:    false,
+		}, {
+			name:   "invalid level",
+			config: &ZstdConfig{Level: 23},
+			err:    true,
+		}, {
+			name:   "missing dictionary id",
+			config: &ZstdConfig{Dictionaries: map[string]*ZstdDictionary{"a": {Content: []byte("content")}}},
+			err:    true,
+		}, {
+			name: "duplicate dictionary id",
+			config: &ZstdConfig{Dictionaries: map[string]*ZstdDictionary{
+				"a": {ID: 1, Content: []byte("content")},
+				"b": {ID: 1, Content: []byte("content")},
+			}},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.err, tt.config.validate() != nil)
+		})
+	}
+}